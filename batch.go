@@ -0,0 +1,245 @@
+package merkledag
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// Errors returned when using a Batch after Abort or Commit was called.
+var (
+	ErrBatchAborted   = errors.New("batch aborted")
+	ErrBatchCommitted = errors.New("batch already committed")
+)
+
+// defaultBatchNodes and defaultBatchSize are the default thresholds at which
+// a Batch flushes its buffer.
+const (
+	defaultBatchNodes = 128
+	defaultBatchSize  = 8 << 20
+)
+
+// BatchOption is a setter for batchOptions
+type BatchOption func(*batchOptions)
+
+type batchOptions struct {
+	maxNodes        int
+	maxSize         int
+	parallelCommits int
+}
+
+// MaxBatchNodes is a BatchOption setting the number of buffered nodes at
+// which a Batch is flushed.
+func MaxBatchNodes(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.maxNodes = n
+	}
+}
+
+// MaxBatchSize is a BatchOption setting the total size of buffered nodes, in
+// bytes of raw block data, at which a Batch is flushed.
+func MaxBatchSize(size int) BatchOption {
+	return func(o *batchOptions) {
+		o.maxSize = size
+	}
+}
+
+// ParallelCommits is a BatchOption setting how many flushes may be in flight
+// at the same time. Adding to a Batch blocks once that limit is reached.
+func ParallelCommits(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.parallelCommits = n
+	}
+}
+
+// Batch buffers nodes added to it and writes them to the underlying
+// DAGService with AddMany, once either the buffered node count or size
+// reaches its limit. Flushes happen in the background, a bounded number at a
+// time.
+//
+// Nodes are only guaranteed to be written once Commit returns successfully.
+// A Batch must be either committed or aborted, to release its resources, and
+// can't be used afterwards. The first error returned by the underlying
+// DAGService is returned by every subsequent call. A Batch is safe for
+// concurrent use.
+type Batch struct {
+	ds     format.DAGService
+	ctx    context.Context
+	cancel context.CancelFunc
+	opts   batchOptions
+
+	lk        sync.Mutex
+	nodes     []format.Node
+	size      int
+	aborted   bool
+	committed bool
+
+	errLk sync.Mutex
+	err   error
+
+	// commits bounds the number of flushes in flight
+	commits chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewBatch returns a Batch writing to ds. If ctx is canceled, in-flight
+// flushes are aborted. When ds was configured with WithBatching, its options
+// apply before opts.
+func NewBatch(ctx context.Context, ds format.DAGService, opts ...BatchOption) *Batch {
+	if dserv, ok := ds.(*dagService); ok && len(dserv.batchOpts) > 0 {
+		opts = append(append([]BatchOption(nil), dserv.batchOpts...), opts...)
+	}
+
+	bopts := batchOptions{
+		maxNodes:        defaultBatchNodes,
+		maxSize:         defaultBatchSize,
+		parallelCommits: runtime.NumCPU(),
+	}
+	for _, o := range opts {
+		o(&bopts)
+	}
+	if bopts.parallelCommits < 1 {
+		bopts.parallelCommits = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &Batch{
+		ds:      ds,
+		ctx:     ctx,
+		cancel:  cancel,
+		opts:    bopts,
+		commits: make(chan struct{}, bopts.parallelCommits),
+	}
+}
+
+// Add buffers a node, flushing the buffer if it is full.
+func (b *Batch) Add(ctx context.Context, nd format.Node) error {
+	return b.AddMany(ctx, []format.Node{nd})
+}
+
+// AddMany buffers several nodes, flushing the buffer if it is full.
+func (b *Batch) AddMany(ctx context.Context, nds []format.Node) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if err := b.checkErr(); err != nil {
+		return err
+	}
+
+	b.nodes = append(b.nodes, nds...)
+	for _, nd := range nds {
+		b.size += len(nd.RawData())
+	}
+
+	if len(b.nodes) >= b.opts.maxNodes || b.size >= b.opts.maxSize {
+		return b.flush(ctx)
+	}
+	return nil
+}
+
+// Commit flushes all buffered nodes and waits until every flush completed.
+// Any further use of the Batch returns ErrBatchCommitted, or the error of a
+// flush.
+func (b *Batch) Commit() error {
+	defer b.cancel()
+
+	b.lk.Lock()
+	if err := b.checkErr(); err != nil {
+		b.lk.Unlock()
+		return err
+	}
+	err := b.flush(b.ctx)
+	b.committed = true
+	b.lk.Unlock()
+	if err != nil {
+		b.cancel()
+		b.wg.Wait()
+		return err
+	}
+
+	b.wg.Wait()
+	return b.flushErr()
+}
+
+// Abort drops the buffered nodes and cancels the flushes in flight. Nodes
+// from flushes which already completed are not removed. Any further use of
+// the Batch returns ErrBatchAborted.
+func (b *Batch) Abort() {
+	// cancel first, to release the callers holding b.lk while waiting for a
+	// commit slot
+	b.cancel()
+
+	b.lk.Lock()
+	b.aborted = true
+	b.nodes = nil
+	b.size = 0
+	b.lk.Unlock()
+
+	b.wg.Wait()
+}
+
+// flush writes the buffered nodes in the background, waiting for a commit
+// slot if needed. b.lk must be held.
+func (b *Batch) flush(ctx context.Context) error {
+	if len(b.nodes) == 0 {
+		return nil
+	}
+
+	select {
+	case b.commits <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.ctx.Done():
+		return b.ctx.Err()
+	}
+
+	nodes := b.nodes
+	b.nodes = make([]format.Node, 0, len(nodes))
+	b.size = 0
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		if err := b.ds.AddMany(b.ctx, nodes); err != nil {
+			b.setErr(err)
+		}
+		<-b.commits
+	}()
+	return nil
+}
+
+// checkErr returns the error to report to callers, if any. b.lk must be held.
+func (b *Batch) checkErr() error {
+	if b.aborted {
+		return ErrBatchAborted
+	}
+	if err := b.flushErr(); err != nil {
+		return err
+	}
+	if b.committed {
+		return ErrBatchCommitted
+	}
+	return nil
+}
+
+// flushErr returns the first flush error, if any.
+func (b *Batch) flushErr() error {
+	b.errLk.Lock()
+	defer b.errLk.Unlock()
+	return b.err
+}
+
+// setErr records the first flush error and aborts the other flushes.
+func (b *Batch) setErr(err error) {
+	b.errLk.Lock()
+	defer b.errLk.Unlock()
+	if b.err == nil {
+		b.err = err
+		b.cancel()
+	}
+}
+
+var _ format.NodeAdder = (*Batch)(nil)
@@ -0,0 +1,158 @@
+package merkledag_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// countingDAG counts the AddMany calls made to a DAGService.
+type countingDAG struct {
+	ipld.DAGService
+
+	lk      sync.Mutex
+	addMany []int
+	ctx     context.Context
+}
+
+func (c *countingDAG) AddMany(ctx context.Context, nds []ipld.Node) error {
+	c.lk.Lock()
+	c.addMany = append(c.addMany, len(nds))
+	c.ctx = ctx
+	c.lk.Unlock()
+	return c.DAGService.AddMany(ctx, nds)
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	ds := &countingDAG{DAGService: dstest.Mock()}
+
+	b := NewBatch(ctx, ds, MaxBatchNodes(10), ParallelCommits(2))
+	var nds []ipld.Node
+	for i := 0; i < 25; i++ {
+		nd := NewRawNode([]byte(fmt.Sprint(i)))
+		nds = append(nds, nd)
+		if err := b.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ds.addMany) != 3 {
+		t.Fatalf("expected 3 flushes, got %v", ds.addMany)
+	}
+	if ds.ctx.Err() == nil {
+		t.Fatal("expected the batch context to be released")
+	}
+	if err := b.Add(ctx, NewRawNode([]byte("late"))); err != ErrBatchCommitted {
+		t.Fatalf("expected ErrBatchCommitted, got %v", err)
+	}
+	for _, nd := range nds {
+		if _, err := ds.Get(ctx, nd.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBatchMaxSize(t *testing.T) {
+	ctx := context.Background()
+	ds := &countingDAG{DAGService: dstest.Mock()}
+
+	b := NewBatch(ctx, ds, MaxBatchSize(100))
+	for i := 0; i < 10; i++ {
+		if err := b.Add(ctx, NewRawNode(make([]byte, 50+i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ds.addMany) != 5 {
+		t.Fatalf("expected 5 flushes, got %v", ds.addMany)
+	}
+}
+
+// blockingDAG blocks AddMany calls until their context is done.
+type blockingDAG struct {
+	ipld.DAGService
+	started chan struct{}
+}
+
+func (b *blockingDAG) AddMany(ctx context.Context, nds []ipld.Node) error {
+	b.started <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestBatchAbortBlockedAdd(t *testing.T) {
+	ctx := context.Background()
+	ds := &blockingDAG{DAGService: dstest.Mock(), started: make(chan struct{}, 1)}
+
+	b := NewBatch(ctx, ds, MaxBatchNodes(1), ParallelCommits(1))
+	if err := b.Add(ctx, NewRawNode([]byte("foo"))); err != nil {
+		t.Fatal(err)
+	}
+	<-ds.started
+
+	// the second add waits for the commit slot of the first flush
+	added := make(chan error, 1)
+	go func() { added <- b.Add(ctx, NewRawNode([]byte("bar"))) }()
+	time.Sleep(10 * time.Millisecond)
+
+	aborted := make(chan struct{})
+	go func() {
+		b.Abort()
+		close(aborted)
+	}()
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Abort blocked by a pending Add")
+	}
+	if err := <-added; err == nil {
+		t.Fatal("expected the pending Add to fail")
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	ctx := context.Background()
+	errBoom := errors.New("boom")
+
+	b := NewBatch(ctx, &ErrorService{Err: errBoom}, MaxBatchNodes(1))
+	_ = b.Add(ctx, NewRawNode([]byte("foo")))
+	if err := b.Commit(); !errors.Is(err, errBoom) {
+		t.Fatalf("expected %v, got %v", errBoom, err)
+	}
+	if err := b.Add(ctx, NewRawNode([]byte("bar"))); !errors.Is(err, errBoom) {
+		t.Fatalf("expected %v, got %v", errBoom, err)
+	}
+}
+
+func TestBatchAbort(t *testing.T) {
+	ctx := context.Background()
+	ds := dstest.Mock()
+
+	b := NewBatch(ctx, ds)
+	nd := NewRawNode([]byte("foo"))
+	if err := b.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	b.Abort()
+
+	if err := b.Commit(); err != ErrBatchAborted {
+		t.Fatalf("expected ErrBatchAborted, got %v", err)
+	}
+	if _, err := ds.Get(ctx, nd.Cid()); !ipld.IsNotFound(err) {
+		t.Fatalf("aborted node was written: %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+package merkledag
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// CacheStats reports the activity of a node cache.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Nodes is the number of cached nodes.
+	Nodes int
+	// Size is the total size of the cached nodes, in bytes of raw block data.
+	Size int
+}
+
+// nodeCache is a least recently used cache of decoded nodes, bounded by the
+// total size of their raw block data.
+type nodeCache struct {
+	maxSize int
+
+	lk      sync.Mutex
+	size    int
+	ll      *list.List
+	entries map[cid.Cid]*list.Element
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	c    cid.Cid
+	size int
+	// nd is a private copy of ProtoNodes, which are mutable and share their
+	// data with their encoded form, and cloned for every caller. Other nodes
+	// are immutable and shared as is.
+	nd format.Node
+}
+
+func newNodeCache(maxSize int) *nodeCache {
+	return &nodeCache{
+		maxSize: maxSize,
+		ll:      list.New(),
+		entries: make(map[cid.Cid]*list.Element),
+	}
+}
+
+func (e *cacheEntry) node() format.Node {
+	if pn, ok := e.nd.(*ProtoNode); ok {
+		return cloneProtoNode(pn)
+	}
+	return e.nd
+}
+
+// cloneProtoNode returns a copy of the encoded node pn sharing no mutable
+// state with it. Unlike Copy, it keeps the links in order and the encoded
+// form, so the CID of a non-canonical node is kept, and nothing is encoded
+// nor decoded again.
+func cloneProtoNode(pn *ProtoNode) *ProtoNode {
+	nn := &ProtoNode{
+		dupPolicy: pn.dupPolicy,
+		encoded:   &immutableProtoNode{append([]byte(nil), pn.encoded.encoded...), pn.encoded.PBNode},
+		cached:    pn.cached,
+		builder:   pn.builder,
+	}
+	if pn.data != nil {
+		nn.data = make([]byte, len(pn.data))
+		copy(nn.data, pn.data)
+	}
+	if len(pn.links) > 0 {
+		nn.links = make([]*format.Link, len(pn.links))
+		links := make([]format.Link, len(pn.links))
+		for i, l := range pn.links {
+			links[i] = *l
+			nn.links[i] = &links[i]
+		}
+	}
+	return nn
+}
+
+// get returns the cached node for c, if any.
+func (nc *nodeCache) get(c cid.Cid) (format.Node, bool) {
+	nc.lk.Lock()
+	el, ok := nc.entries[c]
+	if ok {
+		nc.ll.MoveToFront(el)
+	}
+	nc.lk.Unlock()
+
+	if !ok {
+		nc.misses.Add(1)
+		return nil, false
+	}
+	nc.hits.Add(1)
+	return el.Value.(*cacheEntry).node(), true
+}
+
+// add caches nd, evicting the least recently used nodes as needed. Nodes
+// larger than the cache are not cached.
+func (nc *nodeCache) add(nd format.Node) {
+	e := &cacheEntry{nd: nd}
+	if pn, ok := nd.(*ProtoNode); ok {
+		// decode a private copy once, as the encoded form of pn is shared
+		// with its caller
+		enc, err := pn.EncodeProtobuf(false)
+		if err != nil {
+			return
+		}
+		private, err := unmarshal(append([]byte(nil), enc...))
+		if err != nil {
+			return
+		}
+		private.dupPolicy = pn.dupPolicy
+		private.cached = pn.cached
+		private.builder = pn.CidBuilder()
+		e.nd = private
+	}
+	e.size = len(e.nd.RawData())
+	e.c = e.nd.Cid()
+
+	if e.size > nc.maxSize {
+		return
+	}
+
+	nc.lk.Lock()
+	defer nc.lk.Unlock()
+
+	if el, ok := nc.entries[e.c]; ok {
+		nc.ll.MoveToFront(el)
+		return
+	}
+	nc.entries[e.c] = nc.ll.PushFront(e)
+	nc.size += e.size
+
+	for nc.size > nc.maxSize {
+		nc.removeElement(nc.ll.Back())
+	}
+}
+
+// remove evicts c from the cache.
+func (nc *nodeCache) remove(c cid.Cid) {
+	nc.lk.Lock()
+	defer nc.lk.Unlock()
+	if el, ok := nc.entries[c]; ok {
+		nc.removeElement(el)
+	}
+}
+
+// removeElement must be called with nc.lk held.
+func (nc *nodeCache) removeElement(el *list.Element) {
+	e := nc.ll.Remove(el).(*cacheEntry)
+	delete(nc.entries, e.c)
+	nc.size -= e.size
+}
+
+func (nc *nodeCache) stats() CacheStats {
+	nc.lk.Lock()
+	defer nc.lk.Unlock()
+	return CacheStats{
+		Hits:   nc.hits.Load(),
+		Misses: nc.misses.Load(),
+		Nodes:  nc.ll.Len(),
+		Size:   nc.size,
+	}
+}
+
+// cachingGetter is a NodeGetter serving nodes from a nodeCache when possible.
+type cachingGetter struct {
+	ng    format.NodeGetter
+	cache *nodeCache
+}
+
+// Get returns the cached node, or fetches and caches it.
+func (cg *cachingGetter) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	if nd, ok := cg.cache.get(c); ok {
+		return nd, nil
+	}
+
+	nd, err := cg.ng.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	cg.cache.add(nd)
+	return nd, nil
+}
+
+// GetMany returns the cached nodes right away, and fetches the others.
+func (cg *cachingGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *format.NodeOption {
+	return cg.cache.getMany(ctx, keys, cg.ng.GetMany)
+}
+
+// getMany returns the cached nodes right away, and fetches the others with
+// fetch, caching them.
+func (nc *nodeCache) getMany(ctx context.Context, keys []cid.Cid, fetch func(context.Context, []cid.Cid) <-chan *format.NodeOption) <-chan *format.NodeOption {
+	keys = dedupKeys(keys)
+	out := make(chan *format.NodeOption, len(keys))
+
+	var missing []cid.Cid
+	for _, c := range keys {
+		if nd, ok := nc.get(c); ok {
+			out <- &format.NodeOption{Node: nd}
+		} else {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) == 0 {
+		close(out)
+		return out
+	}
+
+	fetched := fetch(ctx, missing)
+	go func() {
+		defer close(out)
+		for opt := range fetched {
+			if opt.Err == nil {
+				nc.add(opt.Node)
+			}
+			out <- opt
+		}
+	}()
+	return out
+}
+
+// CachingDAGService is a DAGService keeping recently used nodes in memory,
+// in front of another DAGService. Cached nodes are dropped when removed
+// through the CachingDAGService, but not when removed from the underlying
+// DAGService directly.
+type CachingDAGService struct {
+	cachingGetter
+	ds format.DAGService
+}
+
+// NewCachingDAGService returns a CachingDAGService in front of ds, caching up
+// to maxSize bytes of nodes, as measured by the size of their raw block data.
+func NewCachingDAGService(ds format.DAGService, maxSize int) *CachingDAGService {
+	return &CachingDAGService{
+		cachingGetter: cachingGetter{
+			ng:    ds,
+			cache: newNodeCache(maxSize),
+		},
+		ds: ds,
+	}
+}
+
+// Add adds a node to the underlying DAGService and caches it.
+func (s *CachingDAGService) Add(ctx context.Context, nd format.Node) error {
+	if err := s.ds.Add(ctx, nd); err != nil {
+		return err
+	}
+	s.cache.add(nd)
+	return nil
+}
+
+// AddMany adds nodes to the underlying DAGService and caches them.
+func (s *CachingDAGService) AddMany(ctx context.Context, nds []format.Node) error {
+	if err := s.ds.AddMany(ctx, nds); err != nil {
+		return err
+	}
+	for _, nd := range nds {
+		s.cache.add(nd)
+	}
+	return nil
+}
+
+// GetLinks returns the links of a node, using the cached node if any.
+func (s *CachingDAGService) GetLinks(ctx context.Context, c cid.Cid) ([]*format.Link, error) {
+	if c.Type() == cid.Raw {
+		return nil, nil
+	}
+	nd, err := s.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return nd.Links(), nil
+}
+
+// Remove removes a node from the cache and the underlying DAGService.
+func (s *CachingDAGService) Remove(ctx context.Context, c cid.Cid) error {
+	err := s.ds.Remove(ctx, c)
+	s.cache.remove(c)
+	return err
+}
+
+// RemoveMany removes nodes from the cache and the underlying DAGService.
+func (s *CachingDAGService) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	err := s.ds.RemoveMany(ctx, cids)
+	for _, c := range cids {
+		s.cache.remove(c)
+	}
+	return err
+}
+
+// Session returns a NodeGetter sharing this cache, and using a session of the
+// underlying DAGService for fetches if it supports them.
+func (s *CachingDAGService) Session(ctx context.Context) format.NodeGetter {
+	return &cachingGetter{
+		ng:    NewSession(ctx, s.ds),
+		cache: s.cache,
+	}
+}
+
+// Stats returns the cache statistics.
+func (s *CachingDAGService) Stats() CacheStats {
+	return s.cache.stats()
+}
+
+var _ format.DAGService = (*CachingDAGService)(nil)
+var _ format.LinkGetter = (*CachingDAGService)(nil)
+var _ SessionMaker = (*CachingDAGService)(nil)
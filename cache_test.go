@@ -0,0 +1,190 @@
+package merkledag_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func TestCachingDAGService(t *testing.T) {
+	ctx := context.Background()
+	base := dstest.Mock()
+	root := makeDepthTestingGraph(t, base)
+
+	ds := NewCachingDAGService(base, 1<<20)
+	if _, err := ds.Get(ctx, root.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	nd, err := ds.Get(ctx, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := ds.Stats(); st.Hits != 1 || st.Misses != 1 || st.Nodes != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	// mutating a cached node doesn't affect the cache
+	pn := nd.(*ProtoNode)
+	pn.SetData([]byte("changed"))
+	again, err := ds.Get(ctx, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Cid().Equals(root.Cid()) {
+		t.Fatal("cached node was mutated")
+	}
+
+	// GetMany serves the root from the cache and fetches the others
+	keys := []cid.Cid{root.Cid()}
+	for _, l := range root.Links() {
+		keys = append(keys, l.Cid)
+	}
+	count := 0
+	for opt := range ds.GetMany(ctx, keys) {
+		if opt.Err != nil {
+			t.Fatal(opt.Err)
+		}
+		count++
+	}
+	if count != len(keys) {
+		t.Fatalf("expected %d nodes, got %d", len(keys), count)
+	}
+	st := ds.Stats()
+	if st.Hits != 3 || st.Nodes != len(keys) {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	if err := ds.Remove(ctx, root.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Get(ctx, root.Cid()); !ipld.IsNotFound(err) {
+		t.Fatalf("removed node is still served: %v", err)
+	}
+}
+
+func TestCachingDAGServiceDataIsolation(t *testing.T) {
+	ctx := context.Background()
+	ds := NewCachingDAGService(dstest.Mock(), 1<<20)
+
+	// neither the added node nor the returned ones share data with the cache
+	added := NodeWithData([]byte("hello"))
+	if err := ds.Add(ctx, added); err != nil {
+		t.Fatal(err)
+	}
+	added.Data()[0] = 'J'
+	for i := 0; i < 2; i++ {
+		nd, err := ds.Get(ctx, added.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		pn := nd.(*ProtoNode)
+		if string(pn.Data()) != "hello" {
+			t.Fatalf("cached node was mutated: %q", pn.Data())
+		}
+		pn.Data()[0] = 'J'
+		pn.RawData()[len(pn.RawData())-1] = 'J'
+	}
+}
+
+// makeDirectory returns a dag-pb node with n links, sorted by name unless
+// unsorted is set.
+func makeDirectory(t testing.TB, n int, unsorted bool) *ProtoNode {
+	child := NewRawNode([]byte("child")).Cid()
+	var data []byte
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("entry-%03d", i)
+		if unsorted {
+			name = fmt.Sprintf("entry-%03d", n-i)
+		}
+		data = append(data, pbLink(child, []byte(name))...)
+	}
+	nd, err := DecodeProtobuf(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nd
+}
+
+func TestCachingDAGServiceNoDecoding(t *testing.T) {
+	ctx := context.Background()
+	ds := NewCachingDAGService(dstest.Mock(), 1<<20)
+
+	// the links of non-canonical nodes stay in order, and so does their CID
+	dir := makeDirectory(t, 50, true)
+	if err := ds.Add(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	nd, err := ds.Get(ctx, dir.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nd.Cid().Equals(dir.Cid()) || nd.Links()[0].Name != dir.Links()[0].Name {
+		t.Fatal("cached node differs from the added one")
+	}
+
+	// a hit clones the node, decoding it takes an allocation per link field
+	hit := testing.AllocsPerRun(100, func() {
+		if _, err := ds.Get(ctx, dir.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	decode := testing.AllocsPerRun(100, func() {
+		if _, err := DecodeProtobuf(dir.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if hit > 10 || hit >= decode {
+		t.Fatalf("a hit takes %v allocations, and decoding %v", hit, decode)
+	}
+}
+
+func BenchmarkCachingDAGServiceGet(b *testing.B) {
+	ctx := context.Background()
+	ds := NewCachingDAGService(dstest.Mock(), 1<<20)
+	dir := makeDirectory(b, 50, false)
+	if err := ds.Add(ctx, dir); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ds.Get(ctx, dir.Cid()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCachingDAGServiceEviction(t *testing.T) {
+	ctx := context.Background()
+	ds := NewCachingDAGService(dstest.Mock(), 100)
+
+	a := NewRawNode(make([]byte, 60))
+	b := NewRawNode(make([]byte, 61))
+	big := NewRawNode(make([]byte, 101))
+	for _, nd := range []ipld.Node{a, b, big} {
+		if err := ds.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a was evicted to make room for b, and big doesn't fit at all
+	st := ds.Stats()
+	if st.Nodes != 1 || st.Size != 61 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	for _, nd := range []ipld.Node{b, a, big} {
+		if _, err := ds.Get(ctx, nd.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := ds.Stats(); st.Hits != 1 || st.Misses != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
@@ -0,0 +1,453 @@
+package merkledag
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+)
+
+// CarVersion identifies a version of the CAR (Content Addressable aRchive)
+// format.
+type CarVersion int
+
+// These constants define the supported CAR versions.
+const (
+	// CarV1 is a header followed by a flat sequence of blocks.
+	CarV1 CarVersion = 1
+	// CarV2 wraps a CARv1 payload with a fixed size header. No index is
+	// written.
+	CarV2 CarVersion = 2
+)
+
+// carV2Pragma is the fixed prefix of every CARv2 file. It is a valid CARv1
+// header declaring version 2, so that CARv1-only readers fail cleanly.
+var carV2Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+// carV2HeaderSize is the size of the CARv2 header following the pragma:
+// 16 bytes of characteristics, then the data offset, data size and index
+// offset as little endian uint64s.
+const carV2HeaderSize = 40
+
+// maxCarHeaderSize and maxCarSectionSize bound the allocations made while
+// reading a CAR stream, so that a corrupt length prefix can't exhaust memory.
+const (
+	maxCarHeaderSize  = 32 << 20
+	maxCarSectionSize = 32 << 20
+)
+
+// ExportCAR writes the DAG rooted at root to w in the given CAR version,
+// fetching nodes from serv.
+func ExportCAR(ctx context.Context, w io.Writer, root cid.Cid, serv format.NodeGetter, version CarVersion, options ...WalkOption) error {
+	return ExportCARWithDepthLimit(ctx, w, root, -1, serv, version, options...)
+}
+
+// ExportCARWithDepthLimit writes the DAG rooted at root to w in the given CAR
+// version, down to the given depth. depthLim follows the semantics of
+// FetchGraphWithDepthLimit: 0 only exports the root and -1 means unlimited.
+//
+// Blocks are written in depth-first order, each block once, so that the
+// output is deterministic for a given DAG. The CAR header always declares
+// root as its only root, even when SkipRoot is used to leave the root block
+// itself out of the archive. Nodes skipped by the error handling options
+// (e.g. IgnoreMissing) are left out of the archive. Concurrent fetching is
+// not supported and Concurrent/Concurrency options are ignored. The Priority
+// and Checkpoints options, which would change the order of the blocks, are
+// rejected.
+//
+// A CARv2 header records the size of its payload, which is only known once
+// the DAG has been walked. To avoid buffering the whole payload, the DAG is
+// walked twice when exporting CARv2, once to measure it and once to write it.
+func ExportCARWithDepthLimit(ctx context.Context, w io.Writer, root cid.Cid, depthLim int, serv format.NodeGetter, version CarVersion, options ...WalkOption) error {
+	switch version {
+	case CarV1:
+		_, err := writeCarV1(ctx, w, root, depthLim, serv, options)
+		return err
+	case CarV2:
+		size, err := writeCarV1(ctx, io.Discard, root, depthLim, serv, options)
+		if err != nil {
+			return err
+		}
+
+		hdr := make([]byte, carV2HeaderSize)
+		binary.LittleEndian.PutUint64(hdr[16:], uint64(len(carV2Pragma)+carV2HeaderSize))
+		binary.LittleEndian.PutUint64(hdr[24:], size)
+		// leave the index offset at zero: there is no index
+
+		if _, err := w.Write(carV2Pragma); err != nil {
+			return err
+		}
+		if _, err := w.Write(hdr); err != nil {
+			return err
+		}
+
+		written, err := writeCarV1(ctx, w, root, depthLim, serv, options)
+		if err != nil {
+			return err
+		}
+		if written != size {
+			return fmt.Errorf("DAG changed during CARv2 export: wrote %d bytes of payload, header declares %d", written, size)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported CAR version: %d", version)
+	}
+}
+
+// writeCarV1 writes a CARv1 stream to w and returns the number of bytes
+// written.
+func writeCarV1(ctx context.Context, w io.Writer, root cid.Cid, depthLim int, serv format.NodeGetter, options []WalkOption) (uint64, error) {
+	var check walkOptions
+	for _, opt := range options {
+		opt(&check)
+	}
+	if check.Priority != nil || check.OnCheckpoint != nil {
+		return 0, errors.New("CAR export doesn't support the Priority and Checkpoints options")
+	}
+
+	cw := &countingWriter{w: w}
+	if err := writeCarHeader(cw, []cid.Cid{root}); err != nil {
+		return cw.n, err
+	}
+
+	// Same semantics as the visit function in FetchGraphWithDepthLimit: a
+	// node may be visited again if it is found higher in the tree, in which
+	// case its links are explored again but its block isn't written twice.
+	set := make(map[cid.Cid]int)
+	visit := func(c cid.Cid, depth int) bool {
+		oldDepth, ok := set[c]
+
+		if (ok && depthLim < 0) || (depthLim >= 0 && depth > depthLim) {
+			return false
+		}
+
+		if !ok || oldDepth > depth {
+			set[c] = depth
+			return true
+		}
+		return false
+	}
+
+	// a writer failure cancels the walk, so that it is neither retried nor
+	// resumed by error handlers
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	written := cid.NewSet()
+	var writeErr error
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*format.Link, error) {
+		if writeErr != nil {
+			return nil, writeErr
+		}
+		nd, err := serv.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		// nodes which were not visited (i.e. a skipped root) are walked
+		// through but not exported
+		if _, ok := set[c]; ok && written.Visit(c) {
+			if err := writeCarSection(cw, c, nd.RawData()); err != nil {
+				writeErr = err
+				cancel()
+				return nil, err
+			}
+		}
+		return nd.Links(), nil
+	}
+
+	// the walk must be sequential for the output order to be deterministic
+	opts := append(options[:len(options):len(options)], Concurrency(0))
+	err := WalkDepth(ctx, getLinks, root, visit, opts...)
+	if writeErr != nil {
+		return cw.n, writeErr
+	}
+	return cw.n, err
+}
+
+// writeCarHeader writes a CARv1 header declaring the given roots.
+func writeCarHeader(w io.Writer, roots []cid.Cid) error {
+	nd, err := qp.BuildMap(basicnode.Prototype.Map, 2, func(ma ipld.MapAssembler) {
+		qp.MapEntry(ma, "roots", qp.List(int64(len(roots)), func(la ipld.ListAssembler) {
+			for _, r := range roots {
+				qp.ListEntry(la, qp.Link(cidlink.Link{Cid: r}))
+			}
+		}))
+		qp.MapEntry(ma, "version", qp.Int(1))
+	})
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := dagcbor.Encode(nd, &buf); err != nil {
+		return err
+	}
+
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(buf.Len()))
+	if _, err := w.Write(prefix[:n]); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// writeCarSection writes a single block to a CARv1 stream.
+func writeCarSection(w io.Writer, c cid.Cid, data []byte) error {
+	cb := c.Bytes()
+
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(len(cb)+len(data)))
+	if _, err := w.Write(prefix[:n]); err != nil {
+		return err
+	}
+	if _, err := w.Write(cb); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
+
+// ImportCAR reads a CARv1 or CARv2 stream from r and adds its blocks to serv,
+// in batches. It returns the roots declared by the CAR header.
+//
+// Every block is checked against its CID and decoded before being added, so
+// that corrupt or undecodable blocks are rejected. Once all blocks have been
+// added, ImportCAR walks the DAG below every declared root and fails if any
+// node is missing from serv. Blocks already added are not removed on failure.
+//
+// For DAGServices built by this package, the walk only reads the local
+// blockstore, so that missing nodes are reported rather than fetched through
+// the exchange. Other DAGServices are walked as they are.
+func ImportCAR(ctx context.Context, r io.Reader, serv format.DAGService) ([]cid.Cid, error) {
+	br := bufio.NewReader(r)
+	version, roots, err := readCarHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	switch version {
+	case 1:
+	case 2:
+		// what we read was the CARv2 pragma, the CARv1 payload follows
+		var hdr [carV2HeaderSize]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return nil, fmt.Errorf("failed to read CARv2 header: %w", err)
+		}
+		dataOffset := binary.LittleEndian.Uint64(hdr[16:])
+		dataSize := binary.LittleEndian.Uint64(hdr[24:])
+		read := uint64(len(carV2Pragma) + carV2HeaderSize)
+		if dataOffset < read {
+			return nil, fmt.Errorf("invalid CARv2 data offset: %d", dataOffset)
+		}
+		if _, err := io.CopyN(io.Discard, br, int64(dataOffset-read)); err != nil {
+			return nil, fmt.Errorf("failed to seek to CARv2 payload: %w", err)
+		}
+
+		br = bufio.NewReader(io.LimitReader(br, int64(dataSize)))
+		version, roots, err = readCarHeader(br)
+		if err != nil {
+			return nil, err
+		}
+		if version != 1 {
+			return nil, fmt.Errorf("invalid CARv2 payload version: %d", version)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported CAR version: %d", version)
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("CAR header declares no roots")
+	}
+
+	decoder := ipldLegacyDecoder
+	if ds, ok := baseDAGService(serv); ok {
+		decoder = ds.decoder
+	}
+
+	b := NewBatch(ctx, serv)
+	for {
+		blk, err := readCarSection(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			b.Abort()
+			return nil, err
+		}
+
+		nd, err := decoder.DecodeNode(ctx, blk)
+		if err != nil {
+			b.Abort()
+			return nil, fmt.Errorf("failed to decode block %s: %w", blk.Cid(), err)
+		}
+
+		if err := b.Add(ctx, nd); err != nil {
+			b.Abort()
+			return nil, err
+		}
+	}
+	if err := b.Commit(); err != nil {
+		return nil, err
+	}
+
+	// roots may share nodes, only walk them once
+	set := cid.NewSet()
+	for _, root := range roots {
+		if err := Walk(ctx, localGetLinks(serv), root, set.Visit); err != nil {
+			return nil, fmt.Errorf("DAG of root %s is incomplete: %w", root, err)
+		}
+	}
+	return roots, nil
+}
+
+// baseDAGService returns the dagService of this package serv is, or wraps.
+func baseDAGService(serv format.DAGService) (*dagService, bool) {
+	switch s := serv.(type) {
+	case *dagService:
+		return s, true
+	case *CachingDAGService:
+		return baseDAGService(s.ds)
+	default:
+		return nil, false
+	}
+}
+
+// localDAG returns a NodeGetter reading the nodes of serv from its local
+// blockstore only, and decoding them as serv does, when serv is known to
+// this package. It returns serv otherwise.
+func localDAG(serv format.DAGService) format.NodeGetter {
+	s, ok := baseDAGService(serv)
+	if !ok {
+		return serv
+	}
+	return &dagService{
+		Blocks:  bserv.New(s.Blocks.Blockstore(), nil),
+		decoder: s.decoder,
+		verify:  s.verify,
+	}
+}
+
+// localGetLinks returns a GetLinks reading the nodes of serv from its local
+// blockstore only, when serv is known to this package.
+func localGetLinks(serv format.DAGService) GetLinks {
+	return GetLinksDirect(localDAG(serv))
+}
+
+// readCarHeader reads a CARv1 header (or a CARv2 pragma) and returns the
+// version and roots it declares.
+func readCarHeader(br *bufio.Reader) (int64, []cid.Cid, error) {
+	hlen, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read CAR header: %w", err)
+	}
+	if hlen == 0 || hlen > maxCarHeaderSize {
+		return 0, nil, fmt.Errorf("invalid CAR header length: %d", hlen)
+	}
+	buf := make([]byte, hlen)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return 0, nil, fmt.Errorf("failed to read CAR header: %w", err)
+	}
+
+	nb := basicnode.Prototype.Map.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(buf)); err != nil {
+		return 0, nil, fmt.Errorf("invalid CAR header: %w", err)
+	}
+	hdr := nb.Build()
+
+	vnd, err := hdr.LookupByString("version")
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid CAR header: %w", err)
+	}
+	version, err := vnd.AsInt()
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid CAR header version: %w", err)
+	}
+	if version != 1 {
+		return version, nil, nil
+	}
+
+	rnd, err := hdr.LookupByString("roots")
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid CAR header: %w", err)
+	}
+	roots := make([]cid.Cid, 0, rnd.Length())
+	it := rnd.ListIterator()
+	if it == nil {
+		return 0, nil, errors.New("invalid CAR header: roots is not a list")
+	}
+	for !it.Done() {
+		_, v, err := it.Next()
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid CAR header: %w", err)
+		}
+		lnk, err := v.AsLink()
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid CAR root: %w", err)
+		}
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return 0, nil, errors.New("invalid CAR root: not a CID")
+		}
+		roots = append(roots, cl.Cid)
+	}
+	return version, roots, nil
+}
+
+// readCarSection reads the next block from a CARv1 stream, and checks its data
+// against its CID. It returns io.EOF once the stream is exhausted.
+func readCarSection(br *bufio.Reader) (blocks.Block, error) {
+	slen, err := binary.ReadUvarint(br)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read CAR section: %w", err)
+	}
+	if slen == 0 || slen > maxCarSectionSize {
+		return nil, fmt.Errorf("invalid CAR section length: %d", slen)
+	}
+	buf := make([]byte, slen)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return nil, fmt.Errorf("failed to read CAR section: %w", err)
+	}
+
+	n, c, err := cid.CidFromBytes(buf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CID in CAR section: %w", err)
+	}
+	data := buf[n:]
+
+	chk, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !chk.Equals(c) {
+		return nil, fmt.Errorf("block data does not match its CID %s (hashes to %s)", c, chk)
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
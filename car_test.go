@@ -0,0 +1,425 @@
+package merkledag_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/raw"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// readTestCarV1 parses a CARv1 stream and returns its roots and blocks.
+func readTestCarV1(t *testing.T, r io.Reader) ([]cid.Cid, []cid.Cid) {
+	t.Helper()
+	br := bufio.NewReader(r)
+
+	hlen, err := binary.ReadUvarint(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := make([]byte, hlen)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		t.Fatal(err)
+	}
+	nb := basicnode.Prototype.Map.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(hdr)); err != nil {
+		t.Fatal(err)
+	}
+	hnd := nb.Build()
+	version, err := hnd.LookupByString("version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := version.AsInt(); v != 1 {
+		t.Fatalf("expected CAR version 1, got %d", v)
+	}
+	rootsNd, err := hnd.LookupByString("roots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roots []cid.Cid
+	it := rootsNd.ListIterator()
+	for !it.Done() {
+		_, v, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lnk, err := v.AsLink()
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, lnk.(cidlink.Link).Cid)
+	}
+
+	var blks []cid.Cid
+	for {
+		slen, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		section := make([]byte, slen)
+		if _, err := io.ReadFull(br, section); err != nil {
+			t.Fatal(err)
+		}
+		n, c, err := cid.CidFromBytes(section)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chk, err := c.Prefix().Sum(section[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !chk.Equals(c) {
+			t.Fatalf("block data does not match %s", c)
+		}
+		blks = append(blks, c)
+	}
+	return roots, blks
+}
+
+func TestExportCAR(t *testing.T) {
+	ctx := context.Background()
+	ds := dstest.Mock()
+	root := makeDepthTestingGraph(t, ds)
+
+	// expected depth-first order, each block once
+	var expected []cid.Cid
+	set := cid.NewSet()
+	err := Walk(ctx, GetLinksDirect(ds), root.Cid(), func(c cid.Cid) bool {
+		if !set.Visit(c) {
+			return false
+		}
+		expected = append(expected, c)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportCAR(ctx, &buf, root.Cid(), ds, CarV1); err != nil {
+		t.Fatal(err)
+	}
+	roots, blks := readTestCarV1(t, bytes.NewReader(buf.Bytes()))
+	if len(roots) != 1 || !roots[0].Equals(root.Cid()) {
+		t.Fatalf("unexpected roots: %v", roots)
+	}
+	if len(blks) != len(expected) {
+		t.Fatalf("expected %d blocks, got %d", len(expected), len(blks))
+	}
+	for i := range blks {
+		if !blks[i].Equals(expected[i]) {
+			t.Fatalf("block %d: expected %s, got %s", i, expected[i], blks[i])
+		}
+	}
+
+	// exporting again gives the exact same bytes
+	var again bytes.Buffer
+	if err := ExportCAR(ctx, &again, root.Cid(), ds, CarV1, Concurrent()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), again.Bytes()) {
+		t.Fatal("export is not deterministic")
+	}
+
+	// options changing the order are rejected
+	for name, opt := range map[string]WalkOption{
+		"priority":    ShallowFirst(),
+		"checkpoints": Checkpoints(time.Hour, func(*Checkpoint) error { return nil }),
+	} {
+		var out bytes.Buffer
+		if err := ExportCAR(ctx, &out, root.Cid(), ds, CarV1, opt); err == nil || out.Len() != 0 {
+			t.Fatalf("%s: expected an error before writing, got %v", name, err)
+		}
+	}
+
+	var v2 bytes.Buffer
+	if err := ExportCAR(ctx, &v2, root.Cid(), ds, CarV2); err != nil {
+		t.Fatal(err)
+	}
+	out := v2.Bytes()
+	if !bytes.HasPrefix(out, []byte{0x0a, 0xa1, 0x67, 'v', 'e', 'r', 's', 'i', 'o', 'n', 0x02}) {
+		t.Fatal("missing CARv2 pragma")
+	}
+	offset := binary.LittleEndian.Uint64(out[11+16:])
+	size := binary.LittleEndian.Uint64(out[11+24:])
+	if index := binary.LittleEndian.Uint64(out[11+32:]); index != 0 {
+		t.Fatalf("expected no index, got offset %d", index)
+	}
+	if offset+size != uint64(len(out)) {
+		t.Fatalf("bad CARv2 header: offset %d, size %d, file %d", offset, size, len(out))
+	}
+	if !bytes.Equal(out[offset:], buf.Bytes()) {
+		t.Fatal("CARv2 payload differs from CARv1 export")
+	}
+}
+
+// failingWriter fails every write once ok writes succeeded.
+type failingWriter struct {
+	ok     int
+	failed int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.ok > 0 {
+		w.ok--
+		return len(p), nil
+	}
+	w.failed++
+	return 0, errWriteFailed
+}
+
+func TestExportCARWriteError(t *testing.T) {
+	ctx := context.Background()
+	dserv := dstest.Mock()
+	root := makeDepthTestingGraph(t, dserv)
+
+	// the header is written, and the failure of the first block isn't
+	// retried
+	w := &failingWriter{ok: 2}
+	var report RetryReport
+	err := ExportCAR(ctx, w, root.Cid(), dserv, CarV1, Retry(RetryPolicy{Backoff: time.Millisecond}, &report), IgnoreErrors())
+	if !errors.Is(err, errWriteFailed) {
+		t.Fatalf("expected %v, got %v", errWriteFailed, err)
+	}
+	if w.failed != 1 || report.Retries != 0 {
+		t.Fatalf("expected a single failed write, got %d and %d retries", w.failed, report.Retries)
+	}
+}
+
+func TestExportCARWithDepthLimit(t *testing.T) {
+	ctx := context.Background()
+	ds := dstest.Mock()
+	root := makeDepthTestingGraph(t, ds)
+
+	for _, tc := range []struct {
+		depthLim int
+		blocks   int
+	}{{0, 1}, {1, 4}, {2, 6}, {-1, 6}} {
+		var buf bytes.Buffer
+		if err := ExportCARWithDepthLimit(ctx, &buf, root.Cid(), tc.depthLim, ds, CarV1); err != nil {
+			t.Fatal(err)
+		}
+		_, blks := readTestCarV1(t, &buf)
+		if len(blks) != tc.blocks {
+			t.Fatalf("depth %d: expected %d blocks, got %d", tc.depthLim, tc.blocks, len(blks))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ExportCAR(ctx, &buf, root.Cid(), ds, CarV1, SkipRoot()); err != nil {
+		t.Fatal(err)
+	}
+	roots, blks := readTestCarV1(t, &buf)
+	if !roots[0].Equals(root.Cid()) {
+		t.Fatal("root must still be declared when skipped")
+	}
+	if len(blks) != 5 {
+		t.Fatalf("expected 5 blocks, got %d", len(blks))
+	}
+	for _, c := range blks {
+		if c.Equals(root.Cid()) {
+			t.Fatal("skipped root was exported")
+		}
+	}
+}
+
+func TestExportCARMissing(t *testing.T) {
+	ctx := context.Background()
+	ds := dstest.Mock()
+	root := makeDepthTestingGraph(t, ds)
+
+	missing := root.Links()[0].Cid
+	if err := ds.Remove(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err := ExportCAR(ctx, &buf, root.Cid(), ds, CarV1)
+	if !ipld.IsNotFound(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	buf.Reset()
+	if err := ExportCAR(ctx, &buf, root.Cid(), ds, CarV1, IgnoreMissing()); err != nil {
+		t.Fatal(err)
+	}
+	set := cid.NewSet()
+	err = Walk(ctx, GetLinksDirect(ds), root.Cid(), set.Visit, IgnoreMissing())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, blks := readTestCarV1(t, &buf)
+	for _, c := range blks {
+		if c.Equals(missing) {
+			t.Fatal("missing block was exported")
+		}
+	}
+	if len(blks) != set.Len()-1 {
+		t.Fatalf("expected %d blocks, got %d", set.Len()-1, len(blks))
+	}
+}
+
+func TestImportCAR(t *testing.T) {
+	ctx := context.Background()
+	src := dstest.Mock()
+	root := makeDepthTestingGraph(t, src)
+
+	for _, version := range []CarVersion{CarV1, CarV2} {
+		var buf bytes.Buffer
+		if err := ExportCAR(ctx, &buf, root.Cid(), src, version); err != nil {
+			t.Fatal(err)
+		}
+
+		dst := dstest.Mock()
+		roots, err := ImportCAR(ctx, &buf, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(roots) != 1 || !roots[0].Equals(root.Cid()) {
+			t.Fatalf("unexpected roots: %v", roots)
+		}
+
+		nd, err := dst.Get(ctx, root.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		traverseAndCheck(t, nd, dst, func(cid.Cid) bool { return true })
+	}
+}
+
+func TestImportCARCorrupt(t *testing.T) {
+	ctx := context.Background()
+	src := dstest.Mock()
+	root := makeDepthTestingGraph(t, src)
+
+	var buf bytes.Buffer
+	if err := ExportCAR(ctx, &buf, root.Cid(), src, CarV1); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip the last byte of the last block
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xff
+
+	_, err := ImportCAR(ctx, bytes.NewReader(data), dstest.Mock())
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("expected a hash mismatch, got %v", err)
+	}
+}
+
+func TestImportCARIncomplete(t *testing.T) {
+	ctx := context.Background()
+	src := dstest.Mock()
+	root := makeDepthTestingGraph(t, src)
+
+	if err := src.Remove(ctx, root.Links()[0].Cid); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportCAR(ctx, &buf, root.Cid(), src, CarV1, IgnoreMissing()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ImportCAR(ctx, &buf, dstest.Mock())
+	if !ipld.IsNotFound(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+}
+
+func TestImportCARCaching(t *testing.T) {
+	ctx := context.Background()
+	const code = 0x300001 // private use
+	codec := Codec{Code: code, Decode: raw.Decode, Prototype: basicnode.Prototype.Bytes}
+
+	src := newDAGService(t, dstest.Bserv(), WithCodecs(codec))
+	c, err := cid.V1Builder{Codec: code, MhType: mh.SHA2_256}.Sum([]byte("custom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid([]byte("custom"), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := NewDecoder(codec).DecodeNode(ctx, blk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ExportCAR(ctx, &buf, c, src, CarV1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the caching service decodes with the codecs of the wrapped one
+	dst := NewCachingDAGService(newDAGService(t, dstest.Bserv(), WithCodecs(codec)), 1<<20)
+	if _, err := ImportCAR(ctx, &buf, dst); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.Get(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportCARIncompleteOnline(t *testing.T) {
+	ctx := context.Background()
+	full := dstest.Bserv()
+	root := makeDepthTestingGraph(t, NewDAGService(full))
+	missing := root.Links()[0].Cid
+
+	partial := dstest.Mock()
+	var buf bytes.Buffer
+	if err := ExportCAR(ctx, &buf, root.Cid(), NewDAGService(full), CarV1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportCAR(ctx, &buf, partial); err != nil {
+		t.Fatal(err)
+	}
+	if err := partial.Remove(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := ExportCAR(ctx, &buf, root.Cid(), partial, CarV1, IgnoreMissing()); err != nil {
+		t.Fatal(err)
+	}
+
+	// dst could fetch the missing node from full
+	dstore := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	dst := NewDAGService(bserv.New(dstore, offline.Exchange(full.Blockstore())))
+	_, err := ImportCAR(ctx, &buf, dst)
+	if !ipld.IsNotFound(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+	if has, _ := dstore.Has(ctx, missing); has {
+		t.Fatal("missing node was fetched")
+	}
+}
@@ -0,0 +1,323 @@
+package merkledag
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+// CidDepth is a node of a walk, at a given depth.
+type CidDepth struct {
+	Cid   cid.Cid
+	Depth int
+}
+
+// Checkpoint is a snapshot of the progress of a walk, from which it can be
+// resumed with ResumeWalkDepth or ResumeFetchGraph.
+type Checkpoint struct {
+	// Root is the root of the walk.
+	Root cid.Cid
+	// DepthLimit is the depth limit of a FetchGraphWithDepthLimit call, and
+	// -1 for other walks.
+	DepthLimit int
+
+	// Pending nodes are yet to be visited.
+	Pending []CidDepth
+	// Expanding nodes were visited, but their links are yet to be walked.
+	Expanding []CidDepth
+	// Visited nodes were visited by FetchGraph since its previous
+	// checkpoint, with the lowest depth they were seen at, so that taking a
+	// checkpoint doesn't cost more as the walk goes. Resuming the walk takes
+	// the nodes visited before all the previous checkpoints too: saving the
+	// successive checkpoints of a walk under the same key with SaveCheckpoint
+	// accumulates them, and LoadCheckpoint returns them all, until
+	// DeleteCheckpoint is called.
+	//
+	// It is empty for other walks, whose visited set is kept by the visit
+	// function, and for FetchGraph walks using a VisitedSet not kept in
+	// memory, which are resumed with the same set.
+	Visited []CidDepth
+}
+
+// checkpointVersion is the version of the binary encoding of Checkpoints.
+const checkpointVersion = 1
+
+var errCheckpointTruncated = errors.New("checkpoint is truncated")
+
+// MarshalBinary encodes the checkpoint.
+func (cp *Checkpoint) MarshalBinary() ([]byte, error) {
+	buf := []byte{checkpointVersion}
+	buf = appendCid(buf, cp.Root)
+	buf = binary.AppendVarint(buf, int64(cp.DepthLimit))
+	for _, list := range [][]CidDepth{cp.Pending, cp.Expanding, cp.Visited} {
+		buf = binary.AppendUvarint(buf, uint64(len(list)))
+		for _, cd := range list {
+			buf = appendCid(buf, cd.Cid)
+			buf = binary.AppendUvarint(buf, uint64(cd.Depth))
+		}
+	}
+	return buf, nil
+}
+
+func appendCid(buf []byte, c cid.Cid) []byte {
+	b := c.Bytes()
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// UnmarshalBinary decodes a checkpoint encoded by MarshalBinary.
+func (cp *Checkpoint) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errCheckpointTruncated
+	}
+	if data[0] != checkpointVersion {
+		return fmt.Errorf("unknown checkpoint version %d", data[0])
+	}
+	r := checkpointReader{data: data[1:]}
+
+	var out Checkpoint
+	out.Root = r.cid()
+	out.DepthLimit = int(r.varint())
+	for _, list := range []*[]CidDepth{&out.Pending, &out.Expanding, &out.Visited} {
+		n := r.uvarint()
+		if r.err == nil && n > uint64(len(r.data)) {
+			r.err = errCheckpointTruncated
+		}
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			c := r.cid()
+			depth := r.uvarint()
+			*list = append(*list, CidDepth{Cid: c, Depth: int(depth)})
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.data) > 0 {
+		return errors.New("unexpected data after checkpoint")
+	}
+	*cp = out
+	return nil
+}
+
+// checkpointReader decodes the fields of a checkpoint, recording the first
+// error.
+type checkpointReader struct {
+	data []byte
+	err  error
+}
+
+func (r *checkpointReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errCheckpointTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *checkpointReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errCheckpointTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *checkpointReader) cid() cid.Cid {
+	l := r.uvarint()
+	if r.err != nil {
+		return cid.Undef
+	}
+	if l > uint64(len(r.data)) {
+		r.err = errCheckpointTruncated
+		return cid.Undef
+	}
+	c, err := cid.Cast(r.data[:l])
+	if err != nil {
+		r.err = err
+		return cid.Undef
+	}
+	r.data = r.data[l:]
+	return c
+}
+
+// SaveCheckpoint stores a checkpoint under key in d, replacing the previous
+// one. Its visited nodes are added to the ones of the checkpoints previously
+// saved under key, one entry each, below key.
+//
+// The visited nodes of the previous checkpoints are dropped if cp is of a
+// walk with another root or depth limit. Otherwise they are kept until
+// DeleteCheckpoint is called, which must be done once the walk completes, or
+// before key is reused for another walk of the same root: a walk resumed
+// from a checkpoint with stale visited nodes skips their links.
+func SaveCheckpoint(ctx context.Context, d ds.Datastore, key ds.Key, cp *Checkpoint) error {
+	prev, err := d.Get(ctx, key)
+	switch {
+	case err == nil:
+		var old Checkpoint
+		if err := old.UnmarshalBinary(prev); err != nil || old.Root != cp.Root || old.DepthLimit != cp.DepthLimit {
+			if err := deleteCheckpointVisited(ctx, d, key); err != nil {
+				return err
+			}
+		}
+	case !errors.Is(err, ds.ErrNotFound):
+		return err
+	}
+
+	frontier := *cp
+	frontier.Visited = nil
+	data, err := frontier.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	// the frontier goes first: visited nodes without the frontier they were
+	// visited from would stop a resumed walk from walking their links
+	if err := d.Put(ctx, key, data); err != nil {
+		return err
+	}
+
+	visited := checkpointVisitedKey(key)
+	for _, cd := range cp.Visited {
+		k := visited.Child(dshelp.NewKeyFromBinary(cd.Cid.Bytes()))
+		if err := d.Put(ctx, k, binary.AppendUvarint(nil, uint64(cd.Depth))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadCheckpoint loads the checkpoint stored under key in d, with the visited
+// nodes of all the checkpoints saved under key. It returns ds.ErrNotFound if
+// there is none.
+func LoadCheckpoint(ctx context.Context, d ds.Datastore, key ds.Key) (*Checkpoint, error) {
+	data, err := d.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	cp := new(Checkpoint)
+	if err := cp.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	res, err := d.Query(ctx, query.Query{Prefix: checkpointVisitedKey(key).String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		b, err := dshelp.BinaryFromDsKey(ds.NewKey(ds.RawKey(e.Key).BaseNamespace()))
+		if err != nil {
+			return nil, err
+		}
+		c, err := cid.Cast(b)
+		if err != nil {
+			return nil, err
+		}
+		depth, n := binary.Uvarint(e.Value)
+		if n <= 0 {
+			return nil, errCheckpointTruncated
+		}
+		cp.Visited = append(cp.Visited, CidDepth{Cid: c, Depth: int(depth)})
+	}
+	return cp, nil
+}
+
+// DeleteCheckpoint deletes the checkpoint stored under key in d, with the
+// visited nodes of all the checkpoints saved under key.
+func DeleteCheckpoint(ctx context.Context, d ds.Datastore, key ds.Key) error {
+	// the visited nodes go first: without the frontier, nothing tells they
+	// are stale
+	if err := deleteCheckpointVisited(ctx, d, key); err != nil {
+		return err
+	}
+	return d.Delete(ctx, key)
+}
+
+// deleteCheckpointVisited deletes the visited nodes of the checkpoints saved
+// under key.
+func deleteCheckpointVisited(ctx context.Context, d ds.Datastore, key ds.Key) error {
+	res, err := d.Query(ctx, query.Query{Prefix: checkpointVisitedKey(key).String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := d.Delete(ctx, ds.RawKey(e.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkpointVisitedKey is the key below which SaveCheckpoint stores the
+// visited nodes of the checkpoints saved under key.
+func checkpointVisitedKey(key ds.Key) ds.Key {
+	return key.ChildString("visited")
+}
+
+// Checkpoints is a WalkOption calling fn with a checkpoint of the walk every
+// interval, and once more if the walk fails or is canceled. If fn returns an
+// error, the walk is aborted with it.
+//
+// No visit is running while fn is called, so the visit function's state is
+// consistent with the checkpoint and may be saved along with it.
+//
+// Checkpoints make the walk use the concurrent walker, with a single fetcher
+// unless Concurrent or Concurrency is also used.
+func Checkpoints(interval time.Duration, fn func(*Checkpoint) error) WalkOption {
+	return func(walkOptions *walkOptions) {
+		walkOptions.CheckpointInterval = interval
+		walkOptions.OnCheckpoint = fn
+	}
+}
+
+// ResumeWalkDepth resumes a WalkDepth call from one of its checkpoints. The
+// visit function must have the state it had when the checkpoint was taken:
+// the expanding nodes of the checkpoint are not visited again, and the
+// pending ones are. Likewise, a VisitedSet passed with WithVisitedSet must be
+// the one of the interrupted walk.
+func ResumeWalkDepth(ctx context.Context, getLinks GetLinks, cp *Checkpoint, visit func(cid.Cid, int) bool, options ...WalkOption) error {
+	opts := &walkOptions{}
+	for _, opt := range options {
+		opt(opts)
+	}
+	opts.initProgress()
+	opts.reporting()
+	getLinks = opts.retrying(getLinks)
+
+	frontier := make([]cidDepth, 0, len(cp.Expanding)+len(cp.Pending))
+	for _, cd := range cp.Expanding {
+		frontier = append(frontier, cidDepth{cid: cd.Cid, depth: cd.Depth, skipVisit: true})
+	}
+	for _, cd := range cp.Pending {
+		frontier = append(frontier, cidDepth{cid: cd.Cid, depth: cd.Depth})
+	}
+	if opts.VisitedSet != nil {
+		return walkFallible(ctx, visitWithSet(opts.VisitedSet, visit), func(ctx context.Context, visit func(cid.Cid, int) bool) error {
+			return parallelWalkDepth(ctx, getLinks, cp.Root, frontier, opts.report.visiting(visit), opts)
+		})
+	}
+	return parallelWalkDepth(ctx, getLinks, cp.Root, frontier, opts.report.visiting(visit), opts)
+}
@@ -0,0 +1,259 @@
+package merkledag_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// makeTree makes a tree of the given depth, where every inner node has
+// fanout children.
+func makeTree(t *testing.T, dserv ipld.DAGService, depth, fanout int, prefix string) ipld.Node {
+	nd := NodeWithData([]byte(prefix))
+	if depth > 0 {
+		for i := 0; i < fanout; i++ {
+			child := makeTree(t, dserv, depth-1, fanout, fmt.Sprintf("%s/%d", prefix, i))
+			if err := nd.AddNodeLink(fmt.Sprint(i), child); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := dserv.Add(context.Background(), nd); err != nil {
+		t.Fatal(err)
+	}
+	return nd
+}
+
+// interruptingDAG records the fetched nodes, and calls interrupt once a
+// given number of nodes were fetched.
+type interruptingDAG struct {
+	ipld.DAGService
+
+	lk        sync.Mutex
+	fetched   *cid.Set
+	gets      int
+	after     int
+	interrupt func()
+	delay     time.Duration
+}
+
+func (d *interruptingDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	time.Sleep(d.delay)
+	d.lk.Lock()
+	d.gets++
+	if d.gets == d.after && d.interrupt != nil {
+		d.interrupt()
+	}
+	d.lk.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	nd, err := d.DAGService.Get(ctx, c)
+	if err == nil {
+		d.lk.Lock()
+		d.fetched.Add(c)
+		d.lk.Unlock()
+	}
+	return nd, err
+}
+
+func TestCheckpointEncoding(t *testing.T) {
+	ctx := context.Background()
+	c1 := NodeWithData([]byte("1")).Cid()
+	c2 := NewRawNode([]byte("2")).Cid()
+	cp := &Checkpoint{
+		Root:       c1,
+		DepthLimit: -1,
+		Pending:    []CidDepth{{Cid: c2, Depth: 3}},
+		Expanding:  []CidDepth{{Cid: c1, Depth: 0}},
+		Visited:    []CidDepth{{Cid: c1, Depth: 0}, {Cid: c2, Depth: 1}},
+	}
+
+	store := ds.NewMapDatastore()
+	key := ds.NewKey("/walks/1")
+	if _, err := LoadCheckpoint(ctx, store, key); err != ds.ErrNotFound {
+		t.Fatalf("expected ds.ErrNotFound, got %v", err)
+	}
+	if err := SaveCheckpoint(ctx, store, key, cp); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCheckpoint(ctx, store, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(loaded.Visited, func(a, b CidDepth) int { return a.Depth - b.Depth })
+	if fmt.Sprint(loaded) != fmt.Sprint(cp) {
+		t.Fatalf("expected %v, got %v", cp, loaded)
+	}
+
+	// the visited nodes of successive checkpoints accumulate
+	next := &Checkpoint{Root: c1, DepthLimit: -1, Visited: []CidDepth{{Cid: c2, Depth: 0}}}
+	if err := SaveCheckpoint(ctx, store, key, next); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = LoadCheckpoint(ctx, store, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Pending) != 0 || len(loaded.Visited) != 2 || loaded.Visited[0].Depth != 0 || loaded.Visited[1].Depth != 0 {
+		t.Fatalf("unexpected checkpoint %v", loaded)
+	}
+
+	data, err := cp.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(data); i++ {
+		if err := new(Checkpoint).UnmarshalBinary(data[:i]); err == nil {
+			t.Fatalf("decoding %d bytes out of %d should fail", i, len(data))
+		}
+	}
+}
+
+func TestResumeFetchGraph(t *testing.T) {
+	src := dstest.Mock()
+	root := makeTree(t, src, 3, 4, "root")
+	all := cid.NewSet()
+	if err := Walk(context.Background(), GetLinksDirect(src), root.Cid(), all.Visit); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dserv := &interruptingDAG{DAGService: src, fetched: cid.NewSet(), after: 20, interrupt: cancel}
+
+	// checkpoints only include the nodes visited since the previous one
+	store := ds.NewMapDatastore()
+	key := ds.NewKey("/fetch")
+	var cp *Checkpoint
+	visited := cid.NewSet()
+	err := FetchGraph(ctx, root.Cid(), dserv, Concurrency(4), Checkpoints(time.Microsecond, func(c *Checkpoint) error {
+		cp = c
+		for _, cd := range c.Visited {
+			if !visited.Visit(cd.Cid) {
+				t.Errorf("%s is in several checkpoints", cd.Cid)
+			}
+		}
+		return SaveCheckpoint(context.Background(), store, key, c)
+	}))
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if cp == nil {
+		t.Fatal("no checkpoint on cancellation")
+	}
+	if len(cp.Pending)+len(cp.Expanding) == 0 || visited.Len() == 0 {
+		t.Fatalf("unexpected checkpoint: %d pending, %d expanding, %d visited",
+			len(cp.Pending), len(cp.Expanding), visited.Len())
+	}
+
+	// load the checkpoint as if the process restarted
+	resumed, err := LoadCheckpoint(context.Background(), store, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resumed.Visited) != visited.Len() {
+		t.Fatalf("expected %d visited nodes, got %d", visited.Len(), len(resumed.Visited))
+	}
+
+	before := dserv.fetched.Len()
+	dserv.interrupt = nil
+	if err := ResumeFetchGraph(context.Background(), resumed, dserv); err != nil {
+		t.Fatal(err)
+	}
+	if dserv.fetched.Len() != all.Len() {
+		t.Fatalf("expected %d nodes to be fetched, got %d", all.Len(), dserv.fetched.Len())
+	}
+	if before == all.Len() {
+		t.Fatal("the first fetch was not interrupted")
+	}
+}
+
+func TestCheckpointKeyReuse(t *testing.T) {
+	ctx := context.Background()
+	src := dstest.Mock()
+	root := makeTree(t, src, 3, 4, "root")
+	all := cid.NewSet()
+	if err := Walk(ctx, GetLinksDirect(src), root.Cid(), all.Visit); err != nil {
+		t.Fatal(err)
+	}
+
+	store := ds.NewMapDatastore()
+	key := ds.NewKey("/fetch")
+	save := func(c *Checkpoint) error { return SaveCheckpoint(ctx, store, key, c) }
+
+	// a checkpoint of another root drops the visited nodes of the previous
+	if err := save(&Checkpoint{Root: NewRawNode([]byte("other")).Cid(), DepthLimit: -1, Visited: []CidDepth{{root.Cid(), 0}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := save(&Checkpoint{Root: root.Cid(), DepthLimit: -1}); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := LoadCheckpoint(ctx, store, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Visited) != 0 {
+		t.Fatalf("expected no visited nodes, got %d", len(cp.Visited))
+	}
+
+	// a complete fetch, whose checkpoint is deleted once done
+	dserv := &interruptingDAG{DAGService: src, fetched: cid.NewSet(), delay: 100 * time.Microsecond}
+	if err := FetchGraph(ctx, root.Cid(), dserv, Checkpoints(time.Microsecond, save)); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteCheckpoint(ctx, store, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCheckpoint(ctx, store, key); !errors.Is(err, ds.ErrNotFound) {
+		t.Fatalf("expected ds.ErrNotFound, got %v", err)
+	}
+
+	// fetching the same root again, with the same key, fetches every node
+	cctx, cancel := context.WithCancel(ctx)
+	dserv = &interruptingDAG{DAGService: src, fetched: cid.NewSet(), after: 20, interrupt: cancel}
+	if err := FetchGraph(cctx, root.Cid(), dserv, Checkpoints(time.Microsecond, save)); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	cp, err = LoadCheckpoint(ctx, store, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv.interrupt = nil
+	if err := ResumeFetchGraph(ctx, cp, dserv); err != nil {
+		t.Fatal(err)
+	}
+	if dserv.fetched.Len() != all.Len() {
+		t.Fatalf("expected %d nodes to be fetched, got %d", all.Len(), dserv.fetched.Len())
+	}
+}
+
+func TestCheckpointError(t *testing.T) {
+	src := dstest.Mock()
+	root := makeTree(t, src, 3, 4, "root")
+	dserv := &interruptingDAG{DAGService: src, fetched: cid.NewSet(), delay: 2 * time.Millisecond}
+
+	errStop := errors.New("stop")
+	var checkpoints int
+	err := FetchGraph(context.Background(), root.Cid(), dserv, Checkpoints(time.Millisecond, func(*Checkpoint) error {
+		checkpoints++
+		return errStop
+	}))
+	if err != errStop {
+		t.Fatalf("expected %v, got %v", errStop, err)
+	}
+	if checkpoints != 1 {
+		t.Fatalf("expected a single checkpoint, got %d", checkpoints)
+	}
+}
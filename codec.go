@@ -0,0 +1,96 @@
+package merkledag
+
+import (
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	legacy "github.com/ipfs/go-ipld-legacy"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/codec/raw"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+)
+
+// Codec describes how a DAGService decodes the blocks of a given codec.
+type Codec struct {
+	// Code is the multicodec code of the blocks, e.g. cid.DagCBOR.
+	Code uint64
+	// Decode parses the block data. When nil, the decoder NewDecoder knows
+	// for Code is used, if any, and otherwise the decoder registered for Code
+	// in go-ipld-prime's global multicodec registry: this is the only way for
+	// a decoder built by NewDecoder to use that registry.
+	Decode codec.Decoder
+	// Prototype is the prototype of the decoded nodes. When nil,
+	// basicnode.Prototype.Any is used.
+	Prototype ipld.NodePrototype
+	// Converter turns decoded nodes into format.Nodes. When nil, nodes are
+	// wrapped in a legacy.LegacyNode. See PrimeCodecs to decode PrimeNodes.
+	Converter legacy.NodeConverter
+}
+
+// legacyNodeConverter wraps nodes decoded by go-ipld-prime, as the
+// legacy.Decoder does for unregistered codecs.
+func legacyNodeConverter(b blocks.Block, nd ipld.Node) (legacy.UniversalNode, error) {
+	return &legacy.LegacyNode{Block: b, Node: nd}, nil
+}
+
+// NewDecoder returns a decoder for dag-pb, raw, dag-cbor and dag-json blocks,
+// and for blocks of the given codecs, which may override the former. Like the
+// decoder shared by the DAGServices built without options, it decodes
+// dag-cbor and dag-json blocks into legacy.LegacyNodes.
+//
+// The decoder does not share registrations with other decoders, and fails to
+// decode blocks of codecs it doesn't know about.
+func NewDecoder(codecs ...Codec) *legacy.Decoder {
+	var reg multicodec.Registry
+	reg.RegisterDecoder(cid.DagProtobuf, dagpb.Decode)
+	reg.RegisterDecoder(cid.Raw, raw.Decode)
+	reg.RegisterDecoder(cid.DagCBOR, dagcbor.Decode)
+	reg.RegisterDecoder(cid.DagJSON, dagjson.Decode)
+	// global holds the codecs decoded with the global registry
+	global := make(map[uint64]bool)
+	for _, c := range codecs {
+		if c.Decode != nil {
+			reg.RegisterDecoder(c.Code, c.Decode)
+			delete(global, c.Code)
+		} else if _, err := reg.LookupDecoder(c.Code); err != nil {
+			global[c.Code] = true
+		}
+	}
+
+	ls := cidlink.DefaultLinkSystem()
+	ls.TrustedStorage = true
+	ls.DecoderChooser = func(lnk ipld.Link) (codec.Decoder, error) {
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("link of unsupported type %T", lnk)
+		}
+		code := cl.Prefix().Codec
+		if global[code] {
+			return multicodec.LookupDecoder(code)
+		}
+		return reg.LookupDecoder(code)
+	}
+
+	d := legacy.NewDecoderWithLS(ls)
+	d.RegisterCodec(cid.DagProtobuf, dagpb.Type.PBNode, ProtoNodeConverter)
+	d.RegisterCodec(cid.Raw, basicnode.Prototype.Bytes, RawNodeConverter)
+	for _, c := range codecs {
+		proto := c.Prototype
+		if proto == nil {
+			proto = basicnode.Prototype.Any
+		}
+		conv := c.Converter
+		if conv == nil {
+			conv = legacyNodeConverter
+		}
+		d.RegisterCodec(c.Code, proto, conv)
+	}
+	return d
+}
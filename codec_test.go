@@ -0,0 +1,137 @@
+package merkledag_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/raw"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func makeCborBlock(t *testing.T, links map[string]cid.Cid) blocks.Block {
+	nd, err := qp.BuildMap(basicnode.Prototype.Map, int64(len(links)), func(ma datamodel.MapAssembler) {
+		for name, c := range links {
+			qp.MapEntry(ma, name, qp.Link(cidlink.Link{Cid: c}))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := dagcbor.Encode(nd, &buf); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: mh.SHA2_256}.Sum(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid(buf.Bytes(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blk
+}
+
+func TestDAGServiceWithCodecs(t *testing.T) {
+	ctx := context.Background()
+	bs := dstest.Bserv()
+	dserv := newDAGService(t, bs, WithCodecs(Codec{Code: cid.DagCBOR, Decode: dagcbor.Decode}))
+
+	leaf := NewRawNode([]byte("leaf"))
+	pbnd := NodeWithData([]byte("pb"))
+	if err := pbnd.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.AddMany(ctx, []ipld.Node{leaf, pbnd}); err != nil {
+		t.Fatal(err)
+	}
+	root := makeCborBlock(t, map[string]cid.Cid{"pb": pbnd.Cid()})
+	if err := bs.AddBlock(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+
+	set := cid.NewSet()
+	if err := Walk(ctx, GetLinksWithDAG(dserv), root.Cid(), set.Visit); err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 3 {
+		t.Fatalf("expected to walk 3 nodes, got %d", set.Len())
+	}
+}
+
+func TestDAGServiceCodecsIsolated(t *testing.T) {
+	ctx := context.Background()
+	const code = 0x300001 // private use
+
+	bs := dstest.Bserv()
+	data := []byte("custom")
+	c, err := cid.V1Builder{Codec: code, MhType: mh.SHA2_256}.Sum(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.AddBlock(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+
+	custom := newDAGService(t, bs, WithCodecs(Codec{
+		Code:      code,
+		Decode:    raw.Decode,
+		Prototype: basicnode.Prototype.Bytes,
+	}))
+	nd, err := custom.Get(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(nd.RawData(), data) {
+		t.Fatal("decoded node has the wrong data")
+	}
+
+	// neither the default decoder nor another instance know about the codec
+	if _, err := NewDAGService(bs).Get(ctx, c); err == nil {
+		t.Fatal("default DAGService should not decode the custom codec")
+	}
+	if _, err := newDAGService(t, bs, WithCodecs()).Get(ctx, c); err == nil {
+		t.Fatal("another DAGService should not decode the custom codec")
+	}
+
+	// the global registry is only used when asked for
+	const globalCode = 0x300002
+	multicodec.RegisterDecoder(globalCode, raw.Decode)
+	gc, err := cid.V1Builder{Codec: globalCode, MhType: mh.SHA2_256}.Sum(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gblk, err := blocks.NewBlockWithCid(data, gc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDecoder().DecodeNode(ctx, gblk); err == nil {
+		t.Fatal("decoder should not use the global registry")
+	}
+	if _, err := NewDecoder(Codec{Code: globalCode}).DecodeNode(ctx, gblk); err != nil {
+		t.Fatal(err)
+	}
+
+	// WithDecoder takes precedence
+	other := newDAGService(t, bs, WithCodecs(), WithDecoder(NewDecoder(Codec{Code: code, Decode: raw.Decode})))
+	if _, err := other.Get(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+}
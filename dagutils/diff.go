@@ -99,33 +99,40 @@ func ApplyChange(ctx context.Context, ds ipld.DAGService, nd *dag.ProtoNode, cs
 // Diff returns a set of changes that transform node 'a' into node 'b'.
 // It only traverses links in the following cases:
 // 1. two node's links number are greater than 0.
-// 2. both of two nodes are ProtoNode.
+// 2. both of two nodes are ProtoNode, or both are PrimeNode.
 // Otherwise, it compares the cid and emits a Mod change object.
 func Diff(ctx context.Context, ds ipld.DAGService, a, b ipld.Node) ([]*Change, error) {
 	if a.Cid() == b.Cid() {
 		return []*Change{}, nil
 	}
 
-	cleanA, okA := a.Copy().(*dag.ProtoNode)
-	cleanB, okB := b.Copy().(*dag.ProtoNode)
-
 	linksA := a.Links()
 	linksB := b.Links()
 
-	if !okA || !okB || (len(linksA) == 0 && len(linksB) == 0) {
+	if !sameKind(a, b) || (len(linksA) == 0 && len(linksB) == 0) {
 		return []*Change{{Type: Mod, Before: a.Cid(), After: b.Cid()}}, nil
 	}
 
+	// links are matched by name, the first link of b with a given name
+	// standing for all of them
+	namesA := make(map[string]struct{}, len(linksA))
+	for _, l := range linksA {
+		namesA[l.Name] = struct{}{}
+	}
+	firstB := make(map[string]*ipld.Link, len(linksB))
+	for _, l := range linksB {
+		if _, ok := firstB[l.Name]; !ok {
+			firstB[l.Name] = l
+		}
+	}
+
 	var out []*Change
 	for _, linkA := range linksA {
-		linkB, _, err := b.ResolveLink([]string{linkA.Name})
-		if err != nil {
+		linkB, ok := firstB[linkA.Name]
+		if !ok {
 			continue
 		}
 
-		cleanA.RemoveNodeLink(linkA.Name)
-		cleanB.RemoveNodeLink(linkA.Name)
-
 		if linkA.Cid == linkB.Cid {
 			continue
 		}
@@ -152,17 +159,37 @@ func Diff(ctx context.Context, ds ipld.DAGService, a, b ipld.Node) ([]*Change, e
 		out = append(out, sub...)
 	}
 
-	for _, l := range cleanA.Links() {
-		out = append(out, &Change{Type: Remove, Path: l.Name, Before: l.Cid})
+	// copies of ProtoNodes have sorted links
+	for _, l := range a.Copy().Links() {
+		if _, ok := firstB[l.Name]; !ok {
+			out = append(out, &Change{Type: Remove, Path: l.Name, Before: l.Cid})
+		}
 	}
 
-	for _, l := range cleanB.Links() {
-		out = append(out, &Change{Type: Add, Path: l.Name, After: l.Cid})
+	for _, l := range b.Copy().Links() {
+		if _, ok := namesA[l.Name]; !ok {
+			out = append(out, &Change{Type: Add, Path: l.Name, After: l.Cid})
+		}
 	}
 
 	return out, nil
 }
 
+// sameKind returns whether a and b are both ProtoNodes or both PrimeNodes,
+// whose links are named.
+func sameKind(a, b ipld.Node) bool {
+	switch a.(type) {
+	case *dag.ProtoNode:
+		_, ok := b.(*dag.ProtoNode)
+		return ok
+	case *dag.PrimeNode:
+		_, ok := b.(*dag.PrimeNode)
+		return ok
+	default:
+		return false
+	}
+}
+
 // Conflict represents two incompatible changes and is returned by MergeDiffs().
 type Conflict struct {
 	A *Change
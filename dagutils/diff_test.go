@@ -8,6 +8,11 @@ import (
 	ipld "github.com/ipfs/go-ipld-format"
 	dag "github.com/ipfs/go-merkledag"
 	mdtest "github.com/ipfs/go-merkledag/test"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	mh "github.com/multiformats/go-multihash"
 )
 
 func TestMergeDiffs(t *testing.T) {
@@ -125,3 +130,59 @@ func TestDiff(t *testing.T) {
 		}
 	}
 }
+
+func TestDiffPrimeNodes(t *testing.T) {
+	ctx := context.Background()
+	ds, err := dag.NewDAGServiceWithOptions(mdtest.Bserv(), dag.WithCodecs(dag.PrimeCodecs(cid.DagCBOR)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	makeNode := func(links map[string]ipld.Node) ipld.Node {
+		nd, err := qp.BuildMap(basicnode.Prototype.Any, int64(len(links)), func(ma datamodel.MapAssembler) {
+			for name, l := range links {
+				qp.MapEntry(ma, name, qp.Link(cidlink.Link{Cid: l.Cid()}))
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pn, err := dag.NewPrimeNode(nd, cid.V1Builder{Codec: cid.DagCBOR, MhType: mh.SHA2_256})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.Add(ctx, pn); err != nil {
+			t.Fatal(err)
+		}
+		return pn
+	}
+
+	one := dag.NewRawNode([]byte("one"))
+	two := dag.NewRawNode([]byte("two"))
+	three := dag.NewRawNode([]byte("three"))
+	if err := ds.AddMany(ctx, []ipld.Node{one, two, three}); err != nil {
+		t.Fatal(err)
+	}
+	a := makeNode(map[string]ipld.Node{
+		"sub": makeNode(map[string]ipld.Node{"x": one}),
+		"old": two,
+	})
+	b := makeNode(map[string]ipld.Node{
+		"sub": makeNode(map[string]ipld.Node{"x": three}),
+		"new": two,
+	})
+
+	changes, err := Diff(ctx, ds, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]ChangeType{"sub/x": Mod, "old": Remove, "new": Add}
+	if len(changes) != len(expect) {
+		t.Fatalf("expected %d changes, got %d", len(expect), len(changes))
+	}
+	for _, c := range changes {
+		if typ, ok := expect[c.Path]; !ok || typ != c.Type {
+			t.Fatalf("unexpected change %v at %q", c.Type, c.Path)
+		}
+	}
+}
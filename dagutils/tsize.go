@@ -0,0 +1,104 @@
+package dagutils
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// TsizeChange is a node rewritten by FixTsizes.
+type TsizeChange struct {
+	// Old is the CID of the node before, and New after the rewrite.
+	Old cid.Cid
+	New cid.Cid
+}
+
+// FixTsizes recomputes the cumulative sizes of the dag-pb DAG under root,
+// bottom up, without trusting the sizes recorded in its links. The nodes
+// with a link of the wrong size, or to a rewritten node, are rewritten and
+// added to ds, up to a new root.
+//
+// It returns the new root, which is the old one if all sizes were right,
+// and the rewritten nodes, children first. Other nodes than dag-pb ones are
+// not walked nor rewritten, and count for their block size.
+func FixTsizes(ctx context.Context, ds ipld.DAGService, root cid.Cid) (ipld.Node, []TsizeChange, error) {
+	f := &tsizeFixer{ds: ds, fixed: make(map[cid.Cid]fixedNode)}
+	_, nd, err := f.fix(ctx, root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nd, f.changes, nil
+}
+
+// fixedNode is the CID of a node once its links have the right sizes, and
+// its cumulative size.
+type fixedNode struct {
+	cid  cid.Cid
+	size uint64
+}
+
+type tsizeFixer struct {
+	ds ipld.DAGService
+	// fixed holds the nodes already fixed, which may be linked many times
+	fixed   map[cid.Cid]fixedNode
+	changes []TsizeChange
+}
+
+// fix fixes the node c, and returns it along with its fixed CID and size.
+// The node is nil if c was already fixed.
+func (f *tsizeFixer) fix(ctx context.Context, c cid.Cid) (fixedNode, ipld.Node, error) {
+	if fn, ok := f.fixed[c]; ok {
+		return fn, nil, nil
+	}
+
+	nd, err := f.ds.Get(ctx, c)
+	if err != nil {
+		return fixedNode{}, nil, err
+	}
+
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		size, err := nd.Size()
+		if err != nil {
+			return fixedNode{}, nil, err
+		}
+		fn := fixedNode{cid: c, size: size}
+		f.fixed[c] = fn
+		return fn, nd, nil
+	}
+
+	links := pn.Links()
+	changed := false
+	for i, l := range links {
+		child, _, err := f.fix(ctx, l.Cid)
+		if err != nil {
+			return fixedNode{}, nil, err
+		}
+		if child.cid != l.Cid || child.size != l.Size {
+			links[i] = &ipld.Link{Name: l.Name, Size: child.size, Cid: child.cid}
+			changed = true
+		}
+	}
+
+	if changed {
+		pn = pn.Copy().(*dag.ProtoNode)
+		if err := pn.SetLinks(links); err != nil {
+			return fixedNode{}, nil, err
+		}
+		if err := f.ds.Add(ctx, pn); err != nil {
+			return fixedNode{}, nil, err
+		}
+		f.changes = append(f.changes, TsizeChange{Old: c, New: pn.Cid()})
+	}
+
+	size, err := pn.Size()
+	if err != nil {
+		return fixedNode{}, nil, err
+	}
+	fn := fixedNode{cid: pn.Cid(), size: size}
+	f.fixed[c] = fn
+	return fn, pn, nil
+}
@@ -0,0 +1,73 @@
+package dagutils
+
+import (
+	"context"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld-format"
+
+	dag "github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+)
+
+func TestFixTsizes(t *testing.T) {
+	ctx := context.Background()
+	bs := mdtest.Bserv()
+	ds := dag.NewDAGService(bs)
+
+	leaf := dag.NewRawNode([]byte("leaf"))
+	good := dag.NodeWithData([]byte("good"))
+	if err := ds.AddMany(ctx, []ipld.Node{leaf, good}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the bad size of mid poisons the sizes of root
+	mid := dag.NodeWithData([]byte("mid"))
+	if err := mid.AddRawLink("leaf", &ipld.Link{Cid: leaf.Cid(), Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	root := dag.NodeWithData([]byte("root"))
+	for _, name := range []string{"a", "b"} {
+		if err := root.AddNodeLink(name, mid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := root.AddNodeLink("c", good); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.AddMany(ctx, []ipld.Node{mid, root}); err != nil {
+		t.Fatal(err)
+	}
+
+	fixed, changes, err := FixTsizes(ctx, ds, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Old != mid.Cid() || changes[1].Old != root.Cid() || changes[1].New != fixed.Cid() {
+		t.Fatalf("expected mid and root to change, got %v", changes)
+	}
+
+	findings, err := dag.Verify(ctx, fixed.Cid(), dag.VerifyOptions{Blocks: bs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 0 {
+		t.Fatalf("expected no findings, got %v", findings)
+	}
+	names := []string{}
+	for _, l := range fixed.Links() {
+		names = append(names, l.Name)
+	}
+	if len(names) != 3 || names[0] != "a" || names[1] != "b" || names[2] != "c" {
+		t.Fatalf("unexpected links %v", names)
+	}
+
+	// a fixed DAG is left alone
+	again, changes, err := FixTsizes(ctx, ds, fixed.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 || again.Cid() != fixed.Cid() {
+		t.Fatalf("expected no change, got %v", changes)
+	}
+}
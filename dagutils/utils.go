@@ -203,12 +203,15 @@ func (e *Editor) rmLink(ctx context.Context, root *dag.ProtoNode, path []string)
 // root node.
 func (e *Editor) Finalize(ctx context.Context, ds ipld.DAGService) (*dag.ProtoNode, error) {
 	nd := e.GetNode()
-	err := copyDag(ctx, nd, e.tmp, ds)
-	return nd, err
+	b := dag.NewBatch(ctx, ds)
+	if err := copyDag(ctx, nd, e.tmp, b); err != nil {
+		b.Abort()
+		return nd, err
+	}
+	return nd, b.Commit()
 }
 
-func copyDag(ctx context.Context, nd ipld.Node, from, to ipld.DAGService) error {
-	// TODO(#4609): make this batch.
+func copyDag(ctx context.Context, nd ipld.Node, from ipld.DAGService, to ipld.NodeAdder) error {
 	err := to.Add(ctx, nd)
 	if err != nil {
 		return err
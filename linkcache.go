@@ -0,0 +1,213 @@
+package merkledag
+
+import (
+	"context"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsns "github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	format "github.com/ipfs/go-ipld-format"
+	pb "github.com/ipfs/go-merkledag/pb"
+)
+
+// LinkCachePrefix is the datastore namespace used by NewLinkCache.
+var LinkCachePrefix = ds.NewKey("links")
+
+// LinkCache is a DAGService storing the links of dag-pb nodes in a datastore,
+// in front of another DAGService. Its GetLinks method answers from the stored
+// links when possible, so walking a DAG through GetLinksWithDAG does not load
+// nor decode block data.
+//
+// Links are stored when nodes are added or their links requested, and deleted
+// when nodes are removed through the LinkCache. Nodes removed from the
+// underlying DAGService directly keep their links stored until Rebuild.
+type LinkCache struct {
+	ds    format.DAGService
+	store ds.Datastore
+}
+
+// NewLinkCache returns a LinkCache in front of dserv, storing links in the
+// LinkCachePrefix namespace of d.
+func NewLinkCache(dserv format.DAGService, d ds.Datastore) *LinkCache {
+	return &LinkCache{
+		ds:    dserv,
+		store: dsns.Wrap(d, LinkCachePrefix),
+	}
+}
+
+func linkCacheKey(c cid.Cid) ds.Key {
+	return dshelp.NewKeyFromBinary(c.Bytes())
+}
+
+// encodeLinks encodes links as a dag-pb node without data. Unlike
+// ProtoNode.EncodeProtobuf, the links are kept in their original order.
+func encodeLinks(links []*format.Link) ([]byte, error) {
+	pbn := &pb.PBNode{Links: make([]*pb.PBLink, len(links))}
+	for i, l := range links {
+		name := l.Name
+		size := l.Size
+		pbn.Links[i] = &pb.PBLink{
+			Hash:  l.Cid.Bytes(),
+			Name:  &name,
+			Tsize: &size,
+		}
+	}
+	return pbn.Marshal()
+}
+
+func decodeLinks(data []byte) ([]*format.Link, error) {
+	var pbn pb.PBNode
+	if err := pbn.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	links := make([]*format.Link, len(pbn.Links))
+	for i, l := range pbn.Links {
+		c, err := cid.Cast(l.GetHash())
+		if err != nil {
+			return nil, fmt.Errorf("link hash #%d is not valid: %w", i, err)
+		}
+		links[i] = &format.Link{
+			Name: l.GetName(),
+			Size: l.GetTsize(),
+			Cid:  c,
+		}
+	}
+	return links, nil
+}
+
+// put stores the links of nd if it is a dag-pb node.
+func (lc *LinkCache) put(ctx context.Context, nd format.Node) error {
+	if nd.Cid().Type() != cid.DagProtobuf {
+		return nil
+	}
+	data, err := encodeLinks(nd.Links())
+	if err != nil {
+		return err
+	}
+	return lc.store.Put(ctx, linkCacheKey(nd.Cid()), data)
+}
+
+// Get fetches a node from the underlying DAGService.
+func (lc *LinkCache) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	return lc.ds.Get(ctx, c)
+}
+
+// GetMany fetches nodes from the underlying DAGService.
+func (lc *LinkCache) GetMany(ctx context.Context, keys []cid.Cid) <-chan *format.NodeOption {
+	return lc.ds.GetMany(ctx, keys)
+}
+
+// GetLinks returns the links of a node. The links of dag-pb nodes are read
+// from the datastore, or stored there after fetching the node. The links of
+// other nodes are requested from the underlying DAGService.
+func (lc *LinkCache) GetLinks(ctx context.Context, c cid.Cid) ([]*format.Link, error) {
+	switch c.Type() {
+	case cid.Raw:
+		return nil, nil
+	case cid.DagProtobuf:
+	default:
+		return format.GetLinks(ctx, lc.ds, c)
+	}
+
+	data, err := lc.store.Get(ctx, linkCacheKey(c))
+	switch err {
+	case nil:
+		return decodeLinks(data)
+	case ds.ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	nd, err := lc.ds.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := lc.put(ctx, nd); err != nil {
+		return nil, err
+	}
+	return nd.Links(), nil
+}
+
+// Add adds a node to the underlying DAGService and stores its links.
+func (lc *LinkCache) Add(ctx context.Context, nd format.Node) error {
+	if err := lc.ds.Add(ctx, nd); err != nil {
+		return err
+	}
+	return lc.put(ctx, nd)
+}
+
+// AddMany adds nodes to the underlying DAGService and stores their links.
+func (lc *LinkCache) AddMany(ctx context.Context, nds []format.Node) error {
+	if err := lc.ds.AddMany(ctx, nds); err != nil {
+		return err
+	}
+	for _, nd := range nds {
+		if err := lc.put(ctx, nd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove removes a node from the underlying DAGService and deletes its links.
+func (lc *LinkCache) Remove(ctx context.Context, c cid.Cid) error {
+	if err := lc.store.Delete(ctx, linkCacheKey(c)); err != nil {
+		return err
+	}
+	return lc.ds.Remove(ctx, c)
+}
+
+// RemoveMany removes nodes from the underlying DAGService and deletes their
+// links.
+func (lc *LinkCache) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	for _, c := range cids {
+		if err := lc.store.Delete(ctx, linkCacheKey(c)); err != nil {
+			return err
+		}
+	}
+	return lc.ds.RemoveMany(ctx, cids)
+}
+
+// Rebuild deletes every stored link list, then walks the DAGs of the given
+// roots through the underlying DAGService, storing the links of their dag-pb
+// nodes.
+func (lc *LinkCache) Rebuild(ctx context.Context, roots ...cid.Cid) error {
+	res, err := lc.store.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := lc.store.Delete(ctx, ds.NewKey(e.Key)); err != nil {
+			return err
+		}
+	}
+
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*format.Link, error) {
+		nd, err := lc.ds.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if err := lc.put(ctx, nd); err != nil {
+			return nil, err
+		}
+		return nd.Links(), nil
+	}
+
+	set := cid.NewSet()
+	for _, root := range roots {
+		if err := Walk(ctx, getLinks, root, set.Visit, Concurrent()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ format.DAGService = (*LinkCache)(nil)
+var _ format.LinkGetter = (*LinkCache)(nil)
@@ -0,0 +1,105 @@
+package merkledag_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// countingGetDAG counts the Get calls made to a DAGService.
+type countingGetDAG struct {
+	ipld.DAGService
+	gets atomic.Int64
+}
+
+func (c *countingGetDAG) Get(ctx context.Context, k cid.Cid) (ipld.Node, error) {
+	c.gets.Add(1)
+	return c.DAGService.Get(ctx, k)
+}
+
+func TestLinkCache(t *testing.T) {
+	ctx := context.Background()
+	dserv := &countingGetDAG{DAGService: dstest.Mock()}
+	lc := NewLinkCache(dserv, dssync.MutexWrap(ds.NewMapDatastore()))
+
+	root := makeDepthTestingGraph(t, lc)
+	expected := cid.NewSet()
+	if err := Walk(ctx, GetLinksDirect(dserv), root.Cid(), expected.Visit); err != nil {
+		t.Fatal(err)
+	}
+
+	dserv.gets.Store(0)
+	set := cid.NewSet()
+	if err := Walk(ctx, GetLinksWithDAG(lc), root.Cid(), set.Visit); err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != expected.Len() {
+		t.Fatalf("expected %d nodes, got %d", expected.Len(), set.Len())
+	}
+	if n := dserv.gets.Load(); n != 0 {
+		t.Fatalf("walking the cached links fetched %d nodes", n)
+	}
+
+	links, err := lc.GetLinks(ctx, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootLinks := root.Links()
+	if len(links) != len(rootLinks) {
+		t.Fatalf("expected %d links, got %d", len(rootLinks), len(links))
+	}
+	for i, l := range links {
+		if l.Name != rootLinks[i].Name || l.Size != rootLinks[i].Size || !l.Cid.Equals(rootLinks[i].Cid) {
+			t.Fatalf("link %d differs: %v != %v", i, l, rootLinks[i])
+		}
+	}
+
+	// removing a node drops its links
+	if err := lc.Remove(ctx, root.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lc.GetLinks(ctx, root.Cid()); !ipld.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestLinkCacheRebuild(t *testing.T) {
+	ctx := context.Background()
+	dserv := &countingGetDAG{DAGService: dstest.Mock()}
+	store := dssync.MutexWrap(ds.NewMapDatastore())
+
+	// nodes added directly to the underlying DAGService are not cached
+	root := makeDepthTestingGraph(t, dserv)
+	lc := NewLinkCache(dserv, store)
+	if err := lc.Rebuild(ctx, root.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	dserv.gets.Store(0)
+	set := cid.NewSet()
+	if err := Walk(ctx, GetLinksWithDAG(lc), root.Cid(), set.Visit); err != nil {
+		t.Fatal(err)
+	}
+	if n := dserv.gets.Load(); n != 0 {
+		t.Fatalf("walking the rebuilt links fetched %d nodes", n)
+	}
+
+	// rebuilding without roots clears the cache
+	if err := lc.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lc.GetLinks(ctx, root.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if n := dserv.gets.Load(); n != 1 {
+		t.Fatalf("expected 1 fetch after clearing the cache, got %d", n)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	blocks "github.com/ipfs/go-block-format"
 	bserv "github.com/ipfs/go-blockservice"
@@ -18,9 +19,10 @@ import (
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 )
 
+// ipldLegacyDecoder is shared by the DAGServices built without a decoder
+// option, and by WrapSession. See NewDecoder for isolated decoders.
 var ipldLegacyDecoder *legacy.Decoder
 
-// TODO: Don't require global registries
 func init() {
 	d := legacy.NewDecoder()
 	d.RegisterCodec(cid.DagProtobuf, dagpb.Type.PBNode, ProtoNodeConverter)
@@ -35,6 +37,7 @@ const progressContextKey contextKey = "progress"
 
 // NewDAGService constructs a new DAGService (using the default implementation).
 // Note that the default implementation is also an ipld.LinkGetter.
+// See NewDAGServiceWithOptions to configure it.
 func NewDAGService(bs bserv.BlockService) *dagService {
 	return &dagService{
 		Blocks:  bs,
@@ -42,40 +45,116 @@ func NewDAGService(bs bserv.BlockService) *dagService {
 	}
 }
 
+// NewDAGServiceWithOptions constructs a new DAGService (using the default
+// implementation) configured by the given options. It returns an error if
+// the options are invalid.
+func NewDAGServiceWithOptions(bs bserv.BlockService, opts ...Option) (*dagService, error) {
+	var o dagServiceOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	decoder := o.decoder
+	if decoder == nil {
+		if o.codecs != nil {
+			decoder = NewDecoder(o.codecs...)
+		} else {
+			decoder = ipldLegacyDecoder
+		}
+	}
+
+	n := &dagService{
+		Blocks:    bs,
+		decoder:   decoder,
+		builder:   o.builder,
+		verify:    o.verify,
+		batchOpts: o.batchOpts,
+		tracer:    o.tracer,
+	}
+	if o.cacheSize > 0 {
+		n.cache = newNodeCache(o.cacheSize)
+	}
+	return n, nil
+}
+
 // dagService is an IPFS Merkle DAG service.
 // - the root is virtual (like a forest)
 // - stores nodes' data in a BlockService
-// TODO: should cache Nodes that are in memory, and be
-//
-//	able to free some of them when vm pressure is high
+// - caches decoded nodes only if configured to, see WithNodeCache
 type dagService struct {
 	Blocks  bserv.BlockService
 	decoder *legacy.Decoder
+
+	builder   cid.Builder
+	verify    VerifyPolicy
+	cache     *nodeCache
+	batchOpts []BatchOption
+	tracer    Tracer
+}
+
+// trace starts tracing an operation, see WithTracer.
+func (n *dagService) trace(ctx context.Context, op string) (context.Context, func(error)) {
+	if n.tracer == nil {
+		return ctx, func(error) {}
+	}
+	return n.tracer(ctx, op)
 }
 
 // Add adds a node to the dagService, storing the block in the BlockService
-func (n *dagService) Add(ctx context.Context, nd format.Node) error {
+func (n *dagService) Add(ctx context.Context, nd format.Node) (err error) {
 	if n == nil { // FIXME remove this assertion. protect with constructor invariant
 		return fmt.Errorf("dagService is nil")
 	}
 
-	return n.Blocks.AddBlock(ctx, nd)
+	ctx, end := n.trace(ctx, "Add")
+	defer func() { end(err) }()
+
+	if err := n.Blocks.AddBlock(ctx, nd); err != nil {
+		return err
+	}
+	if n.cache != nil {
+		n.cache.add(nd)
+	}
+	return nil
 }
 
-func (n *dagService) AddMany(ctx context.Context, nds []format.Node) error {
+func (n *dagService) AddMany(ctx context.Context, nds []format.Node) (err error) {
+	ctx, end := n.trace(ctx, "AddMany")
+	defer func() { end(err) }()
+
 	blks := make([]blocks.Block, len(nds))
 	for i, nd := range nds {
 		blks[i] = nd
 	}
-	return n.Blocks.AddBlocks(ctx, blks)
+	if err := n.Blocks.AddBlocks(ctx, blks); err != nil {
+		return err
+	}
+	if n.cache != nil {
+		for _, nd := range nds {
+			n.cache.add(nd)
+		}
+	}
+	return nil
 }
 
 // Get retrieves a node from the dagService, fetching the block in the BlockService
-func (n *dagService) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+func (n *dagService) Get(ctx context.Context, c cid.Cid) (_ format.Node, err error) {
 	if n == nil {
 		return nil, fmt.Errorf("dagService is nil")
 	}
 
+	ctx, end := n.trace(ctx, "Get")
+	defer func() { end(err) }()
+
+	if n.cache != nil {
+		if nd, ok := n.cache.get(c); ok {
+			return nd, nil
+		}
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -84,7 +163,14 @@ func (n *dagService) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
 		return nil, err
 	}
 
-	return n.decoder.DecodeNode(ctx, b)
+	nd, err := decodeBlock(ctx, n.decoder, n.verify, c, b)
+	if err != nil {
+		return nil, err
+	}
+	if n.cache != nil {
+		n.cache.add(nd)
+	}
+	return nd, nil
 }
 
 // GetLinks return the links for the node, the node doesn't necessarily have
@@ -100,22 +186,57 @@ func (n *dagService) GetLinks(ctx context.Context, c cid.Cid) ([]*format.Link, e
 	return node.Links(), nil
 }
 
-func (n *dagService) Remove(ctx context.Context, c cid.Cid) error {
-	return n.Blocks.DeleteBlock(ctx, c)
+func (n *dagService) Remove(ctx context.Context, c cid.Cid) (err error) {
+	ctx, end := n.trace(ctx, "Remove")
+	defer func() { end(err) }()
+
+	err = n.Blocks.DeleteBlock(ctx, c)
+	if n.cache != nil {
+		n.cache.remove(c)
+	}
+	return err
 }
 
-// RemoveMany removes multiple nodes from the DAG. It will likely be faster than
-// removing them individually.
+// RemoveMany removes multiple nodes from the DAG. If the BlockService or its
+// Blockstore implements BatchDeleter, the nodes are removed in a single batch.
+// Otherwise they are removed one at a time.
 //
 // This operation is not atomic. If it returns an error, some nodes may or may
-// not have been removed.
-func (n *dagService) RemoveMany(ctx context.Context, cids []cid.Cid) error {
-	// TODO(#4608): make this batch all the way down.
+// not have been removed. When removing one node at a time, RemoveMany attempts
+// to remove every node and returns a *RemoveError listing the failed ones.
+func (n *dagService) RemoveMany(ctx context.Context, cids []cid.Cid) (err error) {
+	ctx, end := n.trace(ctx, "RemoveMany")
+	defer func() { end(err) }()
+
+	err = n.removeMany(ctx, cids)
+	if n.cache != nil {
+		for _, c := range cids {
+			n.cache.remove(c)
+		}
+	}
+	return err
+}
+
+func (n *dagService) removeMany(ctx context.Context, cids []cid.Cid) error {
+	if bd, ok := n.Blocks.(BatchDeleter); ok {
+		return bd.DeleteBlocks(ctx, cids)
+	}
+	if bd, ok := n.Blocks.Blockstore().(BatchDeleter); ok {
+		return bd.DeleteBlocks(ctx, cids)
+	}
+
+	var failures []RemoveFailure
 	for _, c := range cids {
 		if err := n.Blocks.DeleteBlock(ctx, c); err != nil {
-			return err
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failures = append(failures, RemoveFailure{Cid: c, Err: err})
 		}
 	}
+	if len(failures) > 0 {
+		return &RemoveError{Failures: failures}
+	}
 	return nil
 }
 
@@ -132,9 +253,44 @@ func GetLinksDirect(serv format.NodeGetter) GetLinks {
 	}
 }
 
+// NodeWithData returns a new ProtoNode with the given data, using the CID
+// builder set by WithCidBuilder if any.
+func (n *dagService) NodeWithData(d []byte) *ProtoNode {
+	nd := NodeWithData(d)
+	if n.builder != nil {
+		// the builder was checked by NewDAGServiceWithOptions
+		_ = nd.SetCidBuilder(n.builder)
+	}
+	return nd
+}
+
+// NewRawNode returns a new RawNode with the given data, using the CID
+// builder set by WithCidBuilder if any. CIDv0 builders are upgraded to CIDv1,
+// as CIDv0 can't address raw blocks.
+func (n *dagService) NewRawNode(data []byte) (*RawNode, error) {
+	switch b := n.builder.(type) {
+	case nil:
+		return NewRawNode(data), nil
+	case cid.Prefix:
+		if b.Version == 0 {
+			b.Version = 1
+		}
+		return NewRawNodeWPrefix(data, b)
+	default:
+		return NewRawNodeWPrefix(data, b)
+	}
+}
+
+// Batch returns a new Batch over the dagService, configured by the options
+// set by WithBatching.
+func (n *dagService) Batch(ctx context.Context) *Batch {
+	return NewBatch(ctx, n)
+}
+
 type sesGetter struct {
 	bs      *bserv.Session
 	decoder *legacy.Decoder
+	verify  VerifyPolicy
 }
 
 // Get gets a single node from the DAG.
@@ -145,12 +301,12 @@ func (sg *sesGetter) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
 		return nil, err
 	}
 
-	return sg.decoder.DecodeNode(ctx, blk)
+	return decodeBlock(ctx, sg.decoder, sg.verify, c, blk)
 }
 
 // GetMany gets many nodes at once, batching the request if possible.
 func (sg *sesGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *format.NodeOption {
-	return getNodesFromBG(ctx, sg.bs, keys, sg.decoder)
+	return getNodesFromBG(ctx, sg.bs, keys, sg.decoder, sg.verify)
 }
 
 // WrapSession wraps a blockservice session to satisfy the format.NodeGetter interface
@@ -163,26 +319,71 @@ func WrapSession(s *bserv.Session) format.NodeGetter {
 
 // Session returns a NodeGetter using a new session for block fetches.
 func (n *dagService) Session(ctx context.Context) format.NodeGetter {
-	session := bserv.NewSession(ctx, n.Blocks)
+	sg := n.session(ctx, n.verify)
+	if n.cache != nil {
+		return &cachingGetter{ng: sg, cache: n.cache}
+	}
+	return sg
+}
+
+// session returns a session verifying blocks according to verify, without
+// the node cache.
+func (n *dagService) session(ctx context.Context, verify VerifyPolicy) *sesGetter {
 	return &sesGetter{
-		bs:      session,
+		bs:      bserv.NewSession(ctx, n.Blocks),
 		decoder: n.decoder,
+		verify:  verify,
 	}
 }
 
 // FetchGraph fetches all nodes that are children of the given node
-func FetchGraph(ctx context.Context, root cid.Cid, serv format.DAGService) error {
-	return FetchGraphWithDepthLimit(ctx, root, -1, serv)
+func FetchGraph(ctx context.Context, root cid.Cid, serv format.DAGService, options ...WalkOption) error {
+	return FetchGraphWithDepthLimit(ctx, root, -1, serv, options...)
 }
 
 // FetchGraphWithDepthLimit fetches all nodes that are children to the given
 // node down to the given depth. maxDepth=0 means "only fetch root",
 // maxDepth=1 means "fetch root and its direct children" and so on...
 // maxDepth=-1 means unlimited.
-func FetchGraphWithDepthLimit(ctx context.Context, root cid.Cid, depthLim int, serv format.DAGService) error {
+//
+// Nodes are fetched concurrently, unless overridden by the options. The
+// checkpoints emitted with the Checkpoints option include the nodes visited
+// since the previous checkpoint and the depth limit, so that the fetch can be
+// resumed by ResumeFetchGraph. See Checkpoint.Visited.
+func FetchGraphWithDepthLimit(ctx context.Context, root cid.Cid, depthLim int, serv format.DAGService, options ...WalkOption) error {
+	return fetchGraph(ctx, root, depthLim, nil, serv, options)
+}
+
+// ResumeFetchGraph resumes a FetchGraph or FetchGraphWithDepthLimit call
+// from one of its checkpoints, along with the nodes visited before it, as
+// loaded by LoadCheckpoint.
+func ResumeFetchGraph(ctx context.Context, cp *Checkpoint, serv format.DAGService, options ...WalkOption) error {
+	return fetchGraph(ctx, cp.Root, cp.DepthLimit, cp, serv, options)
+}
+
+func fetchGraph(ctx context.Context, root cid.Cid, depthLim int, resume *Checkpoint, serv format.DAGService, options []WalkOption) error {
 	var ng format.NodeGetter = NewSession(ctx, serv)
 
-	set := make(map[cid.Cid]int)
+	opts := &walkOptions{}
+	for _, opt := range options {
+		opt(opts)
+	}
+	set := opts.VisitedSet
+	if set == nil {
+		set = NewMemoryVisitedSet()
+	}
+	if resume != nil {
+		for _, cd := range resume.Visited {
+			if _, err := set.Visit(ctx, cd.Cid, cd.Depth); err != nil {
+				return err
+			}
+		}
+	}
+	// checkpoints only include the nodes visited since the previous one
+	ms, _ := set.(*memoryVisitedSet)
+	if ms != nil && opts.OnCheckpoint != nil {
+		ms.trackChanges()
+	}
 
 	// Visit function returns true when:
 	// * The element is not in the set and we're not over depthLim
@@ -190,35 +391,74 @@ func FetchGraphWithDepthLimit(ctx context.Context, root cid.Cid, depthLim int, s
 	//   than currently seen (if we find it higher in the tree we'll need
 	//   to explore deeper than before).
 	// depthLim = -1 means we only return true if the element is not in the
-	// set.
-	visit := func(c cid.Cid, depth int) bool {
-		oldDepth, ok := set[c]
-
-		if (ok && depthLim < 0) || (depthLim >= 0 && depth > depthLim) {
-			return false
+	// set, so every element is recorded at depth 0.
+	visit := func(ctx context.Context, c cid.Cid, depth int) (bool, error) {
+		if depthLim < 0 {
+			depth = 0
+		} else if depth > depthLim {
+			return false, nil
 		}
-
-		if !ok || oldDepth > depth {
-			set[c] = depth
-			return true
-		}
-		return false
+		return set.Visit(ctx, c, depth)
 	}
 
 	// If we have a ProgressTracker, we wrap the visit function to handle it
-	v, _ := ctx.Value(progressContextKey).(*ProgressTracker)
-	if v == nil {
-		return WalkDepth(ctx, GetLinksDirect(ng), root, visit, Concurrent())
+	if v, _ := ctx.Value(progressContextKey).(*ProgressTracker); v != nil {
+		visitSet := visit
+		visit = func(ctx context.Context, c cid.Cid, depth int) (bool, error) {
+			ok, err := visitSet(ctx, c, depth)
+			if ok {
+				v.Increment()
+			}
+			return ok, err
+		}
 	}
 
-	visitProgress := func(c cid.Cid, depth int) bool {
-		if visit(c, depth) {
-			v.Increment()
-			return true
+	options = append([]WalkOption{Concurrent()}, options...)
+	options = append(options, func(o *walkOptions) {
+		// the set is used by visit, not by the walker
+		o.VisitedSet = nil
+
+		// checkpoints are taken while no visit is running
+		onCheckpoint := o.OnCheckpoint
+		if onCheckpoint == nil {
+			return
+		}
+		o.OnCheckpoint = func(cp *Checkpoint) error {
+			cp.DepthLimit = depthLim
+			if ms != nil {
+				cp.Visited = ms.changes()
+			}
+			return onCheckpoint(cp)
+		}
+	})
+
+	getLinks := GetLinksDirect(ng)
+	if opts.OnProgress != nil {
+		// report the fetched bytes, and estimate the total from the root
+		progress := &walkProgress{report: opts.OnProgress}
+		options = append(options, func(o *walkOptions) {
+			o.progress = progress
+		})
+		getLinks = func(ctx context.Context, c cid.Cid) ([]*format.Link, error) {
+			nd, err := ng.Get(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			var total uint64
+			if c == root {
+				total, _ = nd.Size()
+			}
+			progress.addBytes(len(nd.RawData()), total)
+			return nd.Links(), nil
 		}
-		return false
 	}
-	return WalkDepth(ctx, GetLinksDirect(ng), root, visitProgress, Concurrent())
+
+	return walkFallible(ctx, visit, func(ctx context.Context, visit func(cid.Cid, int) bool) error {
+		if resume != nil {
+			return ResumeWalkDepth(ctx, getLinks, resume, visit, options...)
+		}
+		return WalkDepth(ctx, getLinks, root, visit, options...)
+	})
 }
 
 // GetMany gets many nodes from the DAG at once.
@@ -227,7 +467,35 @@ func FetchGraphWithDepthLimit(ctx context.Context, root cid.Cid, depthLim int, s
 // error indicating that it failed to do so. It is up to the caller to verify
 // that it received all nodes.
 func (n *dagService) GetMany(ctx context.Context, keys []cid.Cid) <-chan *format.NodeOption {
-	return getNodesFromBG(ctx, n.Blocks, keys, n.decoder)
+	ctx, end := n.trace(ctx, "GetMany")
+
+	var out <-chan *format.NodeOption
+	if n.cache != nil {
+		out = n.cache.getMany(ctx, keys, n.getMany)
+	} else {
+		out = n.getMany(ctx, keys)
+	}
+	if n.tracer == nil {
+		return out
+	}
+
+	traced := make(chan *format.NodeOption, cap(out))
+	go func() {
+		defer close(traced)
+		var err error
+		for opt := range out {
+			if opt.Err != nil && err == nil {
+				err = opt.Err
+			}
+			traced <- opt
+		}
+		end(err)
+	}()
+	return traced
+}
+
+func (n *dagService) getMany(ctx context.Context, keys []cid.Cid) <-chan *format.NodeOption {
+	return getNodesFromBG(ctx, n.Blocks, keys, n.decoder, n.verify)
 }
 
 func dedupKeys(keys []cid.Cid) []cid.Cid {
@@ -241,7 +509,16 @@ func dedupKeys(keys []cid.Cid) []cid.Cid {
 	return set.Keys()
 }
 
-func getNodesFromBG(ctx context.Context, bs bserv.BlockGetter, keys []cid.Cid, decoder *legacy.Decoder) <-chan *format.NodeOption {
+// decodeBlock decodes b, read for c, with decoder, after verifying it
+// according to verify.
+func decodeBlock(ctx context.Context, decoder *legacy.Decoder, verify VerifyPolicy, c cid.Cid, b blocks.Block) (format.Node, error) {
+	if err := verifyData(verify, c, b.RawData()); err != nil {
+		return nil, err
+	}
+	return decoder.DecodeNode(ctx, b)
+}
+
+func getNodesFromBG(ctx context.Context, bs bserv.BlockGetter, keys []cid.Cid, decoder *legacy.Decoder, verify VerifyPolicy) <-chan *format.NodeOption {
 	keys = dedupKeys(keys)
 
 	out := make(chan *format.NodeOption, len(keys))
@@ -260,7 +537,7 @@ func getNodesFromBG(ctx context.Context, bs bserv.BlockGetter, keys []cid.Cid, d
 					return
 				}
 
-				nd, err := decoder.DecodeNode(ctx, b)
+				nd, err := decodeBlock(ctx, decoder, verify, b.Cid(), b)
 				if err != nil {
 					out <- &format.NodeOption{Err: err}
 					return
@@ -301,6 +578,25 @@ type walkOptions struct {
 	SkipRoot     bool
 	Concurrency  int
 	ErrorHandler func(c cid.Cid, err error) error
+
+	CheckpointInterval time.Duration
+	OnCheckpoint       func(*Checkpoint) error
+
+	VisitedSet VisitedSet
+
+	RateLimiter    *tokenBucket
+	Priority       func(cid.Cid, int) float64
+	MinConcurrency int
+	TargetLatency  time.Duration
+
+	RetryPolicy *RetryPolicy
+	RetryReport *RetryReport
+
+	OnProgress func(ProgressEvent)
+	// progress is set up by initProgress, unless the walk sets its own
+	progress *walkProgress
+
+	report *WalkReport
 }
 
 // WalkOption is a setter for walkOptions
@@ -404,9 +700,23 @@ func WalkDepth(ctx context.Context, getLinks GetLinks, c cid.Cid, visit func(cid
 	for _, opt := range options {
 		opt(opts)
 	}
+	opts.initProgress()
+	opts.reporting()
+	getLinks = opts.retrying(getLinks)
+
+	if opts.VisitedSet != nil {
+		return walkFallible(ctx, visitWithSet(opts.VisitedSet, visit), func(ctx context.Context, visit func(cid.Cid, int) bool) error {
+			return walkDepth(ctx, getLinks, c, visit, opts)
+		})
+	}
+	return walkDepth(ctx, getLinks, c, visit, opts)
+}
 
-	if opts.Concurrency > 1 {
-		return parallelWalkDepth(ctx, getLinks, c, visit, opts)
+func walkDepth(ctx context.Context, getLinks GetLinks, c cid.Cid, visit func(cid.Cid, int) bool, opts *walkOptions) error {
+	visit = opts.report.visiting(visit)
+	if opts.Concurrency > 1 || opts.OnCheckpoint != nil || opts.Priority != nil {
+		frontier := []cidDepth{{cid: c, skipVisit: opts.SkipRoot}}
+		return parallelWalkDepth(ctx, getLinks, c, frontier, visit, opts)
 	} else {
 		return sequentialWalkDepth(ctx, getLinks, c, 0, visit, opts)
 	}
@@ -419,10 +729,16 @@ func sequentialWalkDepth(ctx context.Context, getLinks GetLinks, root cid.Cid, d
 		}
 	}
 
-	links, err := getLinks(ctx, root)
+	if err := options.RateLimiter.wait(ctx); err != nil {
+		return err
+	}
+	options.progress.fetching()
+	links, fetchErr := getLinks(ctx, root)
+	err := fetchErr
 	if err != nil && options.ErrorHandler != nil {
 		err = options.ErrorHandler(root, err)
 	}
+	options.progress.fetched(depth, fetchErr, err)
 	if err != nil {
 		return err
 	}
@@ -461,21 +777,38 @@ func (p *ProgressTracker) Value() int {
 	return p.Total
 }
 
-func parallelWalkDepth(ctx context.Context, getLinks GetLinks, root cid.Cid, visit func(cid.Cid, int) bool, options *walkOptions) error {
-	type cidDepth struct {
-		cid   cid.Cid
-		depth int
-	}
+// cidDepth is a node to walk, at a given depth.
+type cidDepth struct {
+	cid   cid.Cid
+	depth int
+	// skipVisit is set for nodes whose links are followed without visiting
+	// them: a skipped root, or nodes visited before a walk was resumed.
+	skipVisit bool
+}
+
+// flight is a node handed to the fetchers of parallelWalkDepth.
+type flight struct {
+	cidDepth
+	// visited is set once visit accepted the node, or if skipVisit is set.
+	visited bool
+
+	// score and seq order the flights of a flightQueue with a Priority
+	score float64
+	seq   uint64
+}
 
-	type linksDepth struct {
-		links []*format.Link
-		depth int
+func parallelWalkDepth(ctx context.Context, getLinks GetLinks, root cid.Cid, frontier []cidDepth, visit func(cid.Cid, int) bool, options *walkOptions) error {
+	type result struct {
+		from    *flight
+		links   []*format.Link
+		fetched bool
+		latency time.Duration
 	}
 
-	feed := make(chan cidDepth)
-	out := make(chan linksDepth)
-	done := make(chan struct{})
+	feed := make(chan *flight)
+	results := make(chan result)
 
+	// visitlk guards the calls to visit and the visited state of flights
 	var visitlk sync.Mutex
 	var wg sync.WaitGroup
 
@@ -483,30 +816,37 @@ func parallelWalkDepth(ctx context.Context, getLinks GetLinks, root cid.Cid, vis
 	fetchersCtx, cancel := context.WithCancel(ctx)
 	defer wg.Wait()
 	defer cancel()
-	for i := 0; i < options.Concurrency; i++ {
+	// a walk may use the parallel walker for checkpoints only
+	workers := max(options.Concurrency, 1)
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for cdepth := range feed {
-				ci := cdepth.cid
-				depth := cdepth.depth
-
-				var shouldVisit bool
-
-				// bypass the root if needed
-				if !(options.SkipRoot && depth == 0) {
-					visitlk.Lock()
-					shouldVisit = visit(ci, depth)
-					visitlk.Unlock()
-				} else {
-					shouldVisit = true
+			for f := range feed {
+				ci := f.cid
+				depth := f.depth
+
+				visitlk.Lock()
+				if !f.visited {
+					f.visited = visit(ci, depth)
 				}
+				shouldVisit := f.visited
+				visitlk.Unlock()
 
+				res := result{from: f}
 				if shouldVisit {
-					links, err := getLinks(ctx, ci)
+					if err := options.RateLimiter.wait(fetchersCtx); err != nil {
+						return
+					}
+					options.progress.fetching()
+					start := time.Now()
+					links, fetchErr := getLinks(ctx, ci)
+					res.links, res.fetched, res.latency = links, true, time.Since(start)
+					err := fetchErr
 					if err != nil && options.ErrorHandler != nil {
-						err = options.ErrorHandler(root, err)
+						err = options.ErrorHandler(ci, err)
 					}
+					options.progress.fetched(depth, fetchErr, err)
 					if err != nil {
 						select {
 						case errChan <- err:
@@ -514,71 +854,116 @@ func parallelWalkDepth(ctx context.Context, getLinks GetLinks, root cid.Cid, vis
 						}
 						return
 					}
-
-					outLinks := linksDepth{
-						links: links,
-						depth: depth + 1,
-					}
-
-					select {
-					case out <- outLinks:
-					case <-fetchersCtx.Done():
-						return
-					}
 				}
+
 				select {
-				case done <- struct{}{}:
+				case results <- res:
 				case <-fetchersCtx.Done():
+					return
 				}
 			}
 		}()
 	}
 	defer close(feed)
 
-	send := feed
-	var todoQueue []cidDepth
-	var inProgress int
+	todoQueue := &flightQueue{score: options.Priority}
+	for _, cd := range frontier {
+		todoQueue.push(&flight{cidDepth: cd, visited: cd.skipVisit})
+	}
+	inFlight := make(map[*flight]struct{})
 
-	next := cidDepth{
-		cid:   root,
-		depth: 0,
+	var send chan *flight
+	next := todoQueue.pop()
+	if next == nil {
+		return nil
+	}
+
+	var limit *concurrencyLimit
+	if options.TargetLatency > 0 {
+		limit = &concurrencyLimit{
+			min:    options.MinConcurrency,
+			max:    workers,
+			target: options.TargetLatency,
+			limit:  options.MinConcurrency,
+		}
+	}
+
+	// checkpoint reports the flights yet to be visited or expanded
+	checkpoint := func() error {
+		visitlk.Lock()
+		defer visitlk.Unlock()
+
+		cp := &Checkpoint{Root: root, DepthLimit: -1}
+		add := func(f *flight) {
+			cd := CidDepth{Cid: f.cid, Depth: f.depth}
+			if f.visited {
+				cp.Expanding = append(cp.Expanding, cd)
+			} else {
+				cp.Pending = append(cp.Pending, cd)
+			}
+		}
+		for f := range inFlight {
+			add(f)
+		}
+		if next != nil {
+			add(next)
+		}
+		for _, f := range todoQueue.flights {
+			add(f)
+		}
+		return options.OnCheckpoint(cp)
+	}
+	fail := func(err error) error {
+		if options.OnCheckpoint != nil {
+			_ = checkpoint()
+		}
+		return err
+	}
+
+	var ticks <-chan time.Time
+	if options.OnCheckpoint != nil && options.CheckpointInterval > 0 {
+		ticker := time.NewTicker(options.CheckpointInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
 	}
 
 	for {
+		next = todoQueue.reorder(next)
+		send = nil
+		if next != nil && (limit == nil || len(inFlight) < limit.limit) {
+			send = feed
+		}
+
 		select {
 		case send <- next:
-			inProgress++
-			if len(todoQueue) > 0 {
-				next = todoQueue[0]
-				todoQueue = todoQueue[1:]
-			} else {
-				next = cidDepth{}
-				send = nil
+			inFlight[next] = struct{}{}
+			next = todoQueue.pop()
+		case res := <-results:
+			delete(inFlight, res.from)
+			if limit != nil && res.fetched {
+				limit.observe(res.latency)
+			}
+			for _, lnk := range res.links {
+				todoQueue.push(&flight{cidDepth: cidDepth{
+					cid:   lnk.Cid,
+					depth: res.from.depth + 1,
+				}})
 			}
-		case <-done:
-			inProgress--
-			if inProgress == 0 && !next.cid.Defined() {
+			if next == nil {
+				next = todoQueue.pop()
+			}
+			if len(inFlight) == 0 && next == nil {
 				return nil
 			}
-		case linksDepth := <-out:
-			for _, lnk := range linksDepth.links {
-				cd := cidDepth{
-					cid:   lnk.Cid,
-					depth: linksDepth.depth,
-				}
-
-				if !next.cid.Defined() {
-					next = cd
-					send = feed
-				} else {
-					todoQueue = append(todoQueue, cd)
-				}
+		case <-ticks:
+			if err := checkpoint(); err != nil {
+				return err
 			}
 		case err := <-errChan:
-			return err
+			return fail(err)
 
 		case <-ctx.Done():
-			return ctx.Err()
+			return fail(ctx.Err())
 		}
 	}
 }
@@ -24,6 +24,8 @@ var (
 	ErrNotProtobuf  = fmt.Errorf("expected protobuf dag node")
 	ErrNotRawNode   = fmt.Errorf("expected raw bytes node")
 	ErrLinkNotFound = fmt.Errorf("no link by that name")
+	ErrLinkExists   = fmt.Errorf("a link by that name already exists")
+	ErrLinkIndex    = fmt.Errorf("link index out of range")
 )
 
 var log = logging.Logger("merkledag")
@@ -56,6 +58,9 @@ type ProtoNode struct {
 	linksDirty bool
 	data       []byte
 
+	// dupPolicy applies to the links added with a name already in use
+	dupPolicy DuplicateLinkPolicy
+
 	// cache encoded/marshaled value, kept to make the go-ipld-prime Node interface
 	// work (see prime.go), and to provide a cached []byte encoded form available
 	encoded *immutableProtoNode
@@ -152,6 +157,21 @@ func (ls LinkSlice) Len() int           { return len(ls) }
 func (ls LinkSlice) Swap(a, b int)      { ls[a], ls[b] = ls[b], ls[a] }
 func (ls LinkSlice) Less(a, b int) bool { return ls[a].Name < ls[b].Name }
 
+// DuplicateLinkPolicy decides what happens when a link is added to a
+// ProtoNode with the name of links it already has. Links with an empty name,
+// such as the chunks of UnixFS files, may always repeat.
+type DuplicateLinkPolicy int
+
+const (
+	// AllowDuplicateLinks keeps the links of the same name, next to the new
+	// one. It is the default.
+	AllowDuplicateLinks DuplicateLinkPolicy = iota
+	// RejectDuplicateLinks fails with ErrLinkExists.
+	RejectDuplicateLinks
+	// ReplaceDuplicateLinks removes the links of the same name.
+	ReplaceDuplicateLinks
+)
+
 // NodeWithData builds a new Protonode with the given data.
 func NodeWithData(d []byte) *ProtoNode {
 	return &ProtoNode{data: d}
@@ -174,9 +194,7 @@ func (n *ProtoNode) AddNodeLink(name string, that format.Node) error {
 
 	lnk.Name = name
 
-	n.AddRawLink(name, lnk)
-
-	return nil
+	return n.AddRawLink(name, lnk)
 }
 
 // AddRawLink adds a copy of a link to this node. The link will be added in
@@ -188,6 +206,9 @@ func (n *ProtoNode) AddNodeLink(name string, that format.Node) error {
 // form that did not already have its links sorted, calling AddRawLink and then
 // RemoveNodeLink for the same link, will not result in an identically encoded
 // form as the links will have been sorted.
+//
+// Links of the same name are handled according to the DuplicateLinkPolicy of
+// the node.
 func (n *ProtoNode) AddRawLink(name string, l *format.Link) error {
 	lnk := &format.Link{
 		Name: name,
@@ -197,6 +218,16 @@ func (n *ProtoNode) AddRawLink(name string, l *format.Link) error {
 	if err := checkLink(lnk); err != nil {
 		return err
 	}
+	if name != "" {
+		switch n.dupPolicy {
+		case RejectDuplicateLinks:
+			if _, err := n.GetNodeLink(name); err == nil {
+				return ErrLinkExists
+			}
+		case ReplaceDuplicateLinks:
+			n.removeLinks(name)
+		}
+	}
 	n.links = append(n.links, lnk)
 	n.linksDirty = true // needs a sort
 	n.encoded = nil
@@ -207,6 +238,15 @@ func (n *ProtoNode) AddRawLink(name string, l *format.Link) error {
 // no links with this name, ErrLinkNotFound will be returned. If there are more
 // than one link with this name, they will all be removed.
 func (n *ProtoNode) RemoveNodeLink(name string) error {
+	if !n.removeLinks(name) {
+		return ErrLinkNotFound
+	}
+	return nil
+}
+
+// removeLinks removes the links with the given name, and returns whether
+// there were any.
+func (n *ProtoNode) removeLinks(name string) bool {
 	ref := n.links[:0]
 	found := false
 
@@ -219,7 +259,7 @@ func (n *ProtoNode) RemoveNodeLink(name string) error {
 	}
 
 	if !found {
-		return ErrLinkNotFound
+		return false
 	}
 
 	n.links = ref
@@ -229,10 +269,12 @@ func (n *ProtoNode) RemoveNodeLink(name string) error {
 	n.linksDirty = true
 	n.encoded = nil
 
-	return nil
+	return true
 }
 
-// GetNodeLink returns a copy of the link with the given name.
+// GetNodeLink returns a copy of the link with the given name. If there are
+// more than one link with this name, the first one is returned: see
+// LinkIndices and LinkAt.
 func (n *ProtoNode) GetNodeLink(name string) (*format.Link, error) {
 	for _, l := range n.links {
 		if l.Name == name {
@@ -291,6 +333,7 @@ func (n *ProtoNode) Copy() format.Node {
 	}
 
 	nnode.builder = n.builder
+	nnode.dupPolicy = n.dupPolicy
 
 	return nnode
 }
@@ -485,29 +528,127 @@ func (n *ProtoNode) Multihash() mh.Multihash {
 
 // Links returns a copy of the node's links.
 func (n *ProtoNode) Links() []*format.Link {
+	n.sortLinks()
+	return append([]*format.Link(nil), n.links...)
+}
+
+// sortLinks sorts the links if there was a mutation involving them.
+func (n *ProtoNode) sortLinks() {
 	if n.linksDirty {
-		// there was a mutation involving links, make sure we sort
 		sort.Stable(LinkSlice(n.links))
 		n.linksDirty = false
 		n.encoded = nil
 	}
-	return append([]*format.Link(nil), n.links...)
 }
 
 // SetLinks replaces the node links with a copy of the provided links. Sorting
 // will be applied to the list.
+//
+// Links of the same name are handled according to the DuplicateLinkPolicy of
+// the node: the last one is kept with ReplaceDuplicateLinks.
 func (n *ProtoNode) SetLinks(links []*format.Link) error {
 	for _, lnk := range links {
 		if err := checkLink(lnk); err != nil {
 			return err
 		}
 	}
-	n.links = append([]*format.Link(nil), links...)
+	links = append([]*format.Link(nil), links...)
+	if n.dupPolicy != AllowDuplicateLinks {
+		seen := make(map[string]int, len(links))
+		uniq := links[:0]
+		for _, lnk := range links {
+			i, dup := seen[lnk.Name]
+			switch {
+			case lnk.Name == "" || !dup:
+				seen[lnk.Name] = len(uniq)
+				uniq = append(uniq, lnk)
+			case n.dupPolicy == RejectDuplicateLinks:
+				return ErrLinkExists
+			default:
+				uniq[i] = lnk
+			}
+		}
+		links = uniq
+	}
+	n.links = links
 	n.linksDirty = true // needs a sort
 	n.encoded = nil
 	return nil
 }
 
+// DuplicateLinkPolicy returns the policy for the links added to the node
+// with the name of links it already has.
+func (n *ProtoNode) DuplicateLinkPolicy() DuplicateLinkPolicy {
+	return n.dupPolicy
+}
+
+// SetDuplicateLinkPolicy sets the policy for the links added to the node
+// with the name of links it already has. The links the node already has are
+// left as they are, whatever the policy. It is kept by Copy.
+func (n *ProtoNode) SetDuplicateLinkPolicy(p DuplicateLinkPolicy) {
+	n.dupPolicy = p
+}
+
+// LinkIndices returns the indices in Links of the links with the given name.
+func (n *ProtoNode) LinkIndices(name string) []int {
+	n.sortLinks()
+	var out []int
+	for i, l := range n.links {
+		if l.Name == name {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// LinkAt returns a copy of the link at index i in Links. Along with
+// LinkIndices, it allows telling apart the links of the same name.
+func (n *ProtoNode) LinkAt(i int) (*format.Link, error) {
+	n.sortLinks()
+	if i < 0 || i >= len(n.links) {
+		return nil, ErrLinkIndex
+	}
+	l := n.links[i]
+	return &format.Link{
+		Name: l.Name,
+		Size: l.Size,
+		Cid:  l.Cid,
+	}, nil
+}
+
+// SetLinkAt replaces the link at index i in Links with a copy of l, keeping
+// its name. Unlike UpdateNodeLink, it leaves the other links of the same
+// name untouched.
+func (n *ProtoNode) SetLinkAt(i int, l *format.Link) error {
+	n.sortLinks()
+	if i < 0 || i >= len(n.links) {
+		return ErrLinkIndex
+	}
+	lnk := &format.Link{
+		Name: n.links[i].Name,
+		Size: l.Size,
+		Cid:  l.Cid,
+	}
+	if err := checkLink(lnk); err != nil {
+		return err
+	}
+	n.links[i] = lnk
+	n.encoded = nil
+	return nil
+}
+
+// RemoveLinkAt removes the link at index i in Links. Unlike RemoveNodeLink,
+// it leaves the other links of the same name untouched.
+func (n *ProtoNode) RemoveLinkAt(i int) error {
+	n.sortLinks()
+	if i < 0 || i >= len(n.links) {
+		return ErrLinkIndex
+	}
+	n.links = append(n.links[:i], n.links[i+1:]...)
+	n.encoded = nil
+	return nil
+}
+
 // Resolve is an alias for ResolveLink.
 func (n *ProtoNode) Resolve(path []string) (interface{}, []string, error) {
 	return n.ResolveLink(path)
@@ -537,12 +678,7 @@ func (n *ProtoNode) Tree(p string, depth int) []string {
 		return nil
 	}
 
-	if n.linksDirty {
-		// there was a mutation involving links, make sure we sort
-		sort.Stable(LinkSlice(n.links))
-		n.linksDirty = false
-		n.encoded = nil
-	}
+	n.sortLinks()
 
 	out := make([]string, 0, len(n.links))
 	for _, lnk := range n.links {
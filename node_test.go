@@ -88,6 +88,86 @@ func TestRemoveLink(t *testing.T) {
 	}
 }
 
+func TestDuplicateLinkPolicy(t *testing.T) {
+	other := NodeWithData([]byte("other")).Cid()
+	links := []*ipld.Link{
+		{Name: "a", Cid: sampleCid},
+		{Name: "a", Cid: other},
+		{Name: "", Cid: sampleCid},
+		{Name: "", Cid: sampleCid},
+	}
+
+	nd := &ProtoNode{}
+	nd.SetDuplicateLinkPolicy(RejectDuplicateLinks)
+	if err := nd.SetLinks(links); err != ErrLinkExists {
+		t.Fatalf("expected duplicate links to be rejected, got %v", err)
+	}
+	if err := nd.SetLinks(links[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.AddRawLink("a", links[0]); err != ErrLinkExists {
+		t.Fatalf("expected duplicate link to be rejected, got %v", err)
+	}
+	if err := nd.AddRawLink("", links[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(nd.Links()) != 4 {
+		t.Fatal("number of links incorrect")
+	}
+
+	nd.SetDuplicateLinkPolicy(ReplaceDuplicateLinks)
+	if err := nd.SetLinks(links); err != nil {
+		t.Fatal(err)
+	}
+	if lnk, err := nd.GetNodeLink("a"); err != nil || lnk.Cid != other || len(nd.Links()) != 3 {
+		t.Fatal("expected the last link of the same name to be kept")
+	}
+	if err := nd.AddNodeLink("a", new(ProtoNode)); err != nil {
+		t.Fatal(err)
+	}
+	if len(nd.LinkIndices("a")) != 1 {
+		t.Fatal("expected link to be replaced")
+	}
+	if nd.Copy().(*ProtoNode).DuplicateLinkPolicy() != ReplaceDuplicateLinks {
+		t.Fatal("expected policy to be copied")
+	}
+}
+
+func TestLinkAt(t *testing.T) {
+	other := NodeWithData([]byte("other")).Cid()
+	nd := &ProtoNode{}
+	nd.SetLinks([]*ipld.Link{
+		{Name: "b", Cid: sampleCid},
+		{Name: "a", Cid: sampleCid},
+		{Name: "a", Cid: sampleCid},
+	})
+
+	idx := nd.LinkIndices("a")
+	if len(idx) != 2 || idx[0] != 0 || idx[1] != 1 {
+		t.Fatalf("unexpected indices %v", idx)
+	}
+	if err := nd.SetLinkAt(idx[1], &ipld.Link{Name: "c", Cid: other, Size: 3}); err != nil {
+		t.Fatal(err)
+	}
+	lnk, err := nd.LinkAt(idx[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lnk.Name != "a" || lnk.Cid != other || lnk.Size != 3 {
+		t.Fatalf("unexpected link %v", lnk)
+	}
+
+	if err := nd.RemoveLinkAt(idx[0]); err != nil {
+		t.Fatal(err)
+	}
+	if lnk, err := nd.GetNodeLink("a"); err != nil || lnk.Cid != other {
+		t.Fatal("expected only the first link to be removed")
+	}
+	if _, err := nd.LinkAt(2); err != ErrLinkIndex {
+		t.Fatalf("expected index to be out of range, got %v", err)
+	}
+}
+
 func TestFindLink(t *testing.T) {
 	ctx := context.Background()
 
@@ -0,0 +1,126 @@
+package merkledag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+	legacy "github.com/ipfs/go-ipld-legacy"
+)
+
+// Option is a setter for the options of NewDAGServiceWithOptions.
+type Option func(*dagServiceOptions)
+
+type dagServiceOptions struct {
+	decoder   *legacy.Decoder
+	codecs    []Codec
+	builder   cid.Builder
+	verify    VerifyPolicy
+	cacheSize int
+	batchOpts []BatchOption
+	tracer    Tracer
+}
+
+// Tracer is called when a DAGService operation starts, with the name of the
+// operation (e.g. "Get" or "AddMany"). It returns the context used by the
+// operation, and a function called with the result of the operation once it
+// completes.
+type Tracer func(ctx context.Context, op string) (context.Context, func(error))
+
+// WithDecoder makes the DAGService decode blocks with the given decoder,
+// instead of the decoder shared by the DAGServices built without options.
+// It takes precedence over WithCodecs.
+func WithDecoder(d *legacy.Decoder) Option {
+	return func(o *dagServiceOptions) {
+		o.decoder = d
+	}
+}
+
+// WithCodecs makes the DAGService decode blocks with its own decoder, built
+// by NewDecoder with the given codecs.
+func WithCodecs(codecs ...Codec) Option {
+	return func(o *dagServiceOptions) {
+		o.codecs = append(o.codecs, codecs...)
+	}
+}
+
+// WithStrictDagPB makes the DAGService reject the dag-pb blocks which are
+// not in the canonical dag-pb form with an ErrNonCanonical. It is
+// WithCodecs(StrictDagPB()), and is ignored with WithDecoder.
+func WithStrictDagPB() Option {
+	return WithCodecs(StrictDagPB())
+}
+
+// WithCidBuilder sets the CID builder of the nodes created by the DAGService
+// NodeWithData and NewRawNode methods. The codec of the builder is ignored.
+func WithCidBuilder(builder cid.Builder) Option {
+	return func(o *dagServiceOptions) {
+		o.builder = builder
+	}
+}
+
+// WithHashOnRead makes the DAGService check that the data of every block it
+// reads matches its CID, failing with an ErrHashMismatch otherwise. It is
+// WithVerify(VerifyAll), or disables verification.
+func WithHashOnRead(enabled bool) Option {
+	if !enabled {
+		return WithVerify(nil)
+	}
+	return WithVerify(VerifyAll)
+}
+
+// WithVerify makes the DAGService and its sessions check that the data of
+// the blocks selected by policy matches the requested CID before decoding
+// them, failing with an ErrHashMismatch otherwise. Nodes answered from the
+// node cache are not verified again. See NewVerifiedSession to verify the
+// reads of a single session.
+func WithVerify(policy VerifyPolicy) Option {
+	return func(o *dagServiceOptions) {
+		o.verify = policy
+	}
+}
+
+// WithNodeCache makes the DAGService keep recently used nodes in memory, up
+// to maxSize bytes of raw block data. See NewCachingDAGService.
+func WithNodeCache(maxSize int) Option {
+	return func(o *dagServiceOptions) {
+		o.cacheSize = maxSize
+	}
+}
+
+// WithBatching sets the default options of the Batches created by NewBatch
+// over the DAGService, which are also used by its Batch method. Options given
+// to NewBatch take precedence.
+func WithBatching(opts ...BatchOption) Option {
+	return func(o *dagServiceOptions) {
+		o.batchOpts = append(o.batchOpts, opts...)
+	}
+}
+
+// WithTracer sets a function called for every operation of the DAGService.
+func WithTracer(t Tracer) Option {
+	return func(o *dagServiceOptions) {
+		o.tracer = t
+	}
+}
+
+func (o *dagServiceOptions) validate() error {
+	if o.builder != nil {
+		if err := new(ProtoNode).SetCidBuilder(o.builder); err != nil {
+			return fmt.Errorf("invalid CID builder: %w", err)
+		}
+	}
+	if o.cacheSize < 0 {
+		return errors.New("node cache size must not be negative")
+	}
+	var bopts batchOptions
+	bopts.maxNodes, bopts.maxSize = defaultBatchNodes, defaultBatchSize
+	for _, opt := range o.batchOpts {
+		opt(&bopts)
+	}
+	if bopts.maxNodes < 1 || bopts.maxSize < 1 {
+		return errors.New("batch limits must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package merkledag_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// newDAGService builds a DAGService with options, failing the test if they
+// are invalid.
+func newDAGService(t *testing.T, bs bserv.BlockService, opts ...Option) ipld.DAGService {
+	t.Helper()
+	dserv, err := NewDAGServiceWithOptions(bs, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dserv
+}
+
+func TestNewDAGServiceWithOptionsInvalid(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"cache size":  WithNodeCache(-1),
+		"batch nodes": WithBatching(MaxBatchNodes(0)),
+		"cid builder": WithCidBuilder(cid.Prefix{Version: 1, MhType: 0xfff, MhLength: -1}),
+	} {
+		if _, err := NewDAGServiceWithOptions(dstest.Bserv(), opt); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDAGServiceCidBuilder(t *testing.T) {
+	dserv, err := NewDAGServiceWithOptions(dstest.Bserv(), WithCidBuilder(V1CidPrefix()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nd := dserv.NodeWithData([]byte("foo"))
+	if nd.Cid().Version() != 1 {
+		t.Fatalf("expected a CIDv1, got %s", nd.Cid())
+	}
+
+	dserv, err = NewDAGServiceWithOptions(dstest.Bserv(), WithCidBuilder(V0CidPrefix()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := dserv.NewRawNode([]byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Cid().Type() != cid.Raw || raw.Cid().Version() != 1 {
+		t.Fatalf("expected a CIDv1 raw node, got %s", raw.Cid())
+	}
+}
+
+func TestDAGServiceHashOnRead(t *testing.T) {
+	ctx := context.Background()
+	bs := dstest.Bserv()
+
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: mh.SHA2_256}.Sum([]byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid([]byte("bar"), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.AddBlock(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDAGService(bs).Get(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	dserv, err := NewDAGServiceWithOptions(bs, WithHashOnRead(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dserv.Get(ctx, c); !errors.Is(err, blockstore.ErrHashMismatch) {
+		t.Fatalf("expected a hash mismatch, got %v", err)
+	}
+	if _, err := dserv.Session(ctx).Get(ctx, c); !errors.Is(err, blockstore.ErrHashMismatch) {
+		t.Fatalf("expected a hash mismatch from the session, got %v", err)
+	}
+	for opt := range dserv.GetMany(ctx, []cid.Cid{c}) {
+		if !errors.Is(opt.Err, blockstore.ErrHashMismatch) {
+			t.Fatalf("expected a hash mismatch from GetMany, got %v", opt.Err)
+		}
+	}
+}
+
+func TestDAGServiceNodeCache(t *testing.T) {
+	ctx := context.Background()
+	bs := dstest.Bserv()
+	dserv, err := NewDAGServiceWithOptions(bs, WithNodeCache(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nd := NodeWithData([]byte("foo"))
+	if err := dserv.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+
+	// the block is gone, but the node is still cached
+	if err := bs.DeleteBlock(ctx, nd.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dserv.Get(ctx, nd.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	for opt := range dserv.GetMany(ctx, []cid.Cid{nd.Cid()}) {
+		if opt.Err != nil {
+			t.Fatal(opt.Err)
+		}
+	}
+
+	if err := dserv.Remove(ctx, nd.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dserv.Get(ctx, nd.Cid()); !ipld.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestDAGServiceTracerAndBatching(t *testing.T) {
+	ctx := context.Background()
+
+	var lk sync.Mutex
+	ops := make(map[string]int)
+	tracer := func(ctx context.Context, op string) (context.Context, func(error)) {
+		return ctx, func(err error) {
+			lk.Lock()
+			defer lk.Unlock()
+			if err != nil {
+				op += " failed"
+			}
+			ops[op]++
+		}
+	}
+	dserv, err := NewDAGServiceWithOptions(dstest.Bserv(), WithTracer(tracer), WithBatching(MaxBatchNodes(4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := dserv.Batch(ctx)
+	var cids []cid.Cid
+	for i := 0; i < 10; i++ {
+		nd := NewRawNode([]byte(fmt.Sprint(i)))
+		cids = append(cids, nd.Cid())
+		if err := b.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for opt := range dserv.GetMany(ctx, cids) {
+		if opt.Err != nil {
+			t.Fatal(opt.Err)
+		}
+	}
+	_, _ = dserv.Get(ctx, NodeWithData([]byte("missing")).Cid())
+
+	lk.Lock()
+	defer lk.Unlock()
+	if ops["AddMany"] != 3 {
+		t.Fatalf("expected 3 batched AddMany, got %v", ops)
+	}
+	if ops["GetMany"] != 1 || ops["Get failed"] != 1 {
+		t.Fatalf("unexpected traced operations: %v", ops)
+	}
+}
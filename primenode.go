@@ -0,0 +1,175 @@
+package merkledag
+
+import (
+	"bytes"
+	"strings"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	legacy "github.com/ipfs/go-ipld-legacy"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal"
+)
+
+// PrimeNode is a node of any codec, backed by a go-ipld-prime node, such as
+// a dag-cbor or dag-json node. Its links are named after their path within
+// the node, e.g. "meta/parents/0", so that they can be resolved with
+// Resolve(strings.Split(name, "/")).
+//
+// Like RawNode, a PrimeNode is immutable.
+type PrimeNode struct {
+	blocks.Block
+	ipld.Node
+}
+
+var _ legacy.UniversalNode = &PrimeNode{}
+
+// NewPrimeNode encodes nd with the codec of builder, using the encoders
+// registered in go-ipld-prime's global multicodec registry.
+func NewPrimeNode(nd ipld.Node, builder cid.Builder) (*PrimeNode, error) {
+	codec := builder.GetCodec()
+	enc, err := multicodec.LookupEncoder(codec)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := enc(nd, &buf); err != nil {
+		return nil, err
+	}
+	c, err := builder.Sum(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	blk, err := blocks.NewBlockWithCid(buf.Bytes(), c)
+	if err != nil {
+		return nil, err
+	}
+	return &PrimeNode{blk, nd}, nil
+}
+
+// DecodePrimeBlock is a block decoder for IPLD nodes conforming to
+// `node.DecodeBlockFunc`, decoding blocks of any codec registered in
+// go-ipld-prime's global multicodec registry.
+func DecodePrimeBlock(block blocks.Block) (format.Node, error) {
+	dec, err := multicodec.LookupDecoder(block.Cid().Type())
+	if err != nil {
+		return nil, err
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dec(nb, bytes.NewReader(block.RawData())); err != nil {
+		return nil, err
+	}
+	return &PrimeNode{block, nb.Build()}, nil
+}
+
+var _ format.DecodeBlockFunc = DecodePrimeBlock
+
+// PrimeNodeConverter wraps a decoded go-ipld-prime node and its block in a
+// PrimeNode. It can be used as the Converter of a Codec.
+func PrimeNodeConverter(b blocks.Block, nd ipld.Node) (legacy.UniversalNode, error) {
+	return &PrimeNode{b, nd}, nil
+}
+
+// PrimeCodecs returns the Codecs decoding the blocks of the given codecs into
+// PrimeNodes, e.g. PrimeCodecs(cid.DagCBOR, cid.DagJSON), to use with
+// WithCodecs or NewDecoder.
+func PrimeCodecs(codes ...uint64) []Codec {
+	codecs := make([]Codec, len(codes))
+	for i, code := range codes {
+		codecs[i] = Codec{Code: code, Converter: PrimeNodeConverter}
+	}
+	return codecs
+}
+
+// legacyNode returns a legacy.LegacyNode over the same data, which implements
+// path resolution.
+func (pn *PrimeNode) legacyNode() *legacy.LegacyNode {
+	return &legacy.LegacyNode{Block: pn.Block, Node: pn.Node}
+}
+
+// Links returns the links found anywhere within the node, named after their
+// path, in the iteration order of the node.
+func (pn *PrimeNode) Links() []*format.Link {
+	var links []*format.Link
+	_ = traversal.WalkLocal(pn.Node, func(prog traversal.Progress, n ipld.Node) error {
+		if n.Kind() != ipld.Kind_Link {
+			return nil
+		}
+		lnk, err := n.AsLink()
+		if err != nil {
+			return err
+		}
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil
+		}
+		links = append(links, &format.Link{
+			Name: prog.Path.String(),
+			Cid:  cl.Cid,
+		})
+		return nil
+	})
+	return links
+}
+
+// Resolve resolves a path through this node, stopping at any link boundary
+// and returning the object found as well as the remaining path to traverse.
+func (pn *PrimeNode) Resolve(path []string) (interface{}, []string, error) {
+	obj, rest, err := pn.legacyNode().Resolve(path)
+	if lnk, ok := obj.(*format.Link); ok {
+		lnk.Name = strings.Join(path[:len(path)-len(rest)], "/")
+	}
+	return obj, rest, err
+}
+
+// ResolveLink resolves a path through this node, and returns the link found
+// at the end of it, or at the first link boundary.
+func (pn *PrimeNode) ResolveLink(path []string) (*format.Link, []string, error) {
+	obj, rest, err := pn.Resolve(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	lnk, ok := obj.(*format.Link)
+	if !ok {
+		return nil, rest, legacy.ErrNonLink
+	}
+	return lnk, rest, nil
+}
+
+// Tree lists all paths within the object under 'path', and up to the given
+// depth. To list the entire object (similar to `find .`) pass "" and -1.
+func (pn *PrimeNode) Tree(path string, depth int) []string {
+	return pn.legacyNode().Tree(path, depth)
+}
+
+// Copy returns a copy of the node. As PrimeNodes are immutable, the copy
+// shares the block and go-ipld-prime node.
+func (pn *PrimeNode) Copy() format.Node {
+	return &PrimeNode{pn.Block, pn.Node}
+}
+
+// Size returns the size of the block. The size of the linked nodes is not
+// known.
+func (pn *PrimeNode) Size() (uint64, error) {
+	return uint64(len(pn.RawData())), nil
+}
+
+// Stat returns stats about the node.
+func (pn *PrimeNode) Stat() (*format.NodeStat, error) {
+	return &format.NodeStat{
+		Hash:           pn.Cid().String(),
+		NumLinks:       len(pn.Links()),
+		BlockSize:      len(pn.RawData()),
+		DataSize:       len(pn.RawData()),
+		CumulativeSize: len(pn.RawData()),
+	}, nil
+}
+
+// MarshalJSON returns the dag-json encoding of the node.
+func (pn *PrimeNode) MarshalJSON() ([]byte, error) {
+	return pn.legacyNode().MarshalJSON()
+}
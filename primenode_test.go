@@ -0,0 +1,116 @@
+package merkledag_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	legacy "github.com/ipfs/go-ipld-legacy"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	mh "github.com/multiformats/go-multihash"
+)
+
+var cborBuilder = cid.V1Builder{Codec: cid.DagCBOR, MhType: mh.SHA2_256}
+
+func makePrimeNode(t *testing.T, file, parent cid.Cid) *PrimeNode {
+	nd, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "file", qp.Link(cidlink.Link{Cid: file}))
+		qp.MapEntry(ma, "meta", qp.Map(2, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "name", qp.String("foo"))
+			qp.MapEntry(ma, "parents", qp.List(1, func(la datamodel.ListAssembler) {
+				qp.ListEntry(la, qp.Link(cidlink.Link{Cid: parent}))
+			}))
+		}))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pn, err := NewPrimeNode(nd, cborBuilder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pn
+}
+
+func TestPrimeNode(t *testing.T) {
+	ctx := context.Background()
+	bs := dstest.Bserv()
+	dserv := newDAGService(t, bs, WithCodecs(PrimeCodecs(cid.DagCBOR)...))
+
+	file := NodeWithData([]byte("file"))
+	parent := NewRawNode([]byte("parent"))
+	if err := dserv.AddMany(ctx, []ipld.Node{file, parent}); err != nil {
+		t.Fatal(err)
+	}
+	pn := makePrimeNode(t, file.Cid(), parent.Cid())
+	if err := dserv.Add(ctx, pn); err != nil {
+		t.Fatal(err)
+	}
+
+	nd, err := dserv.Get(ctx, pn.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nd.(*PrimeNode); !ok {
+		t.Fatalf("expected a PrimeNode, got %T", nd)
+	}
+	// PrimeNodes are opt-in
+	if legacyNd, err := NewDAGService(bs).Get(ctx, pn.Cid()); err != nil {
+		t.Fatal(err)
+	} else if _, ok := legacyNd.(*legacy.LegacyNode); !ok {
+		t.Fatalf("expected a LegacyNode by default, got %T", legacyNd)
+	}
+
+	links := nd.Links()
+	if len(links) != 2 {
+		t.Fatalf("expected 2 links, got %d", len(links))
+	}
+	expected := map[string]cid.Cid{"file": file.Cid(), "meta/parents/0": parent.Cid()}
+	for _, l := range links {
+		if !l.Cid.Equals(expected[l.Name]) {
+			t.Fatalf("unexpected link %q to %s", l.Name, l.Cid)
+		}
+		lnk, rest, err := nd.ResolveLink(strings.Split(l.Name, "/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rest) != 0 || lnk.Name != l.Name || !lnk.Cid.Equals(l.Cid) {
+			t.Fatalf("resolved %q to %v, %v", l.Name, lnk, rest)
+		}
+	}
+
+	name, _, err := nd.Resolve([]string{"meta", "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "foo" {
+		t.Fatalf("expected foo, got %v", name)
+	}
+	if tree := nd.Tree("meta", 1); len(tree) != 2 {
+		t.Fatalf("unexpected tree: %v", tree)
+	}
+
+	st, err := nd.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.NumLinks != 2 || st.BlockSize != len(pn.RawData()) {
+		t.Fatalf("unexpected stat: %+v", st)
+	}
+
+	set := cid.NewSet()
+	if err := Walk(ctx, GetLinksWithDAG(dserv), pn.Cid(), set.Visit); err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 3 {
+		t.Fatalf("expected to walk 3 nodes, got %d", set.Len())
+	}
+}
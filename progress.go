@@ -0,0 +1,102 @@
+package merkledag
+
+import (
+	"sync"
+)
+
+// ProgressEvent is a snapshot of the progress of a walk, reported to the
+// function set with OnProgress every time a node is fetched.
+type ProgressEvent struct {
+	// Nodes is the number of nodes fetched.
+	Nodes int
+	// Bytes is the size of the fetched nodes. It is only known to walks
+	// fetching the nodes themselves, such as FetchGraph.
+	Bytes uint64
+	// TotalBytes estimates the size of the whole DAG, from the cumulative
+	// size of its root, or is 0 if unknown. Nodes linked several times are
+	// counted once per link, so Bytes may not reach it.
+	TotalBytes uint64
+
+	// Depth is the depth of the last fetched node.
+	Depth int
+	// InFlight is the number of fetches in progress.
+	InFlight int
+	// Skipped is the number of fetch errors skipped by an error handler.
+	Skipped int
+}
+
+// OnProgress is a WalkOption calling fn with the progress of the walk every
+// time a node is fetched. fn is never called concurrently, and should return
+// quickly as the walk waits for it.
+//
+// Unlike a ProgressTracker, which counts the visited nodes, it also reports
+// fetch errors and, for FetchGraph, the fetched bytes and the estimated size
+// of the DAG.
+func OnProgress(fn func(ProgressEvent)) WalkOption {
+	return func(walkOptions *walkOptions) {
+		walkOptions.OnProgress = fn
+	}
+}
+
+// walkProgress tracks the progress of a walk. Its methods do nothing on a nil
+// walkProgress, when progress isn't reported.
+type walkProgress struct {
+	lk     sync.Mutex
+	event  ProgressEvent
+	report func(ProgressEvent)
+}
+
+// initProgress sets up the progress tracking requested with OnProgress, if
+// the walk was not given its own walkProgress.
+func (wo *walkOptions) initProgress() {
+	if wo.progress == nil && wo.OnProgress != nil {
+		wo.progress = &walkProgress{report: wo.OnProgress}
+	}
+}
+
+// fetching records the start of a fetch.
+func (p *walkProgress) fetching() {
+	if p == nil {
+		return
+	}
+	p.lk.Lock()
+	p.event.InFlight++
+	p.lk.Unlock()
+}
+
+// fetched records the end of a fetch at depth, which failed with fetchErr if
+// not nil. err is the error left by the error handler, if any: the walk is
+// then aborted, and no progress is reported.
+func (p *walkProgress) fetched(depth int, fetchErr, err error) {
+	if p == nil {
+		return
+	}
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	p.event.InFlight--
+	if err != nil {
+		return
+	}
+	if fetchErr != nil {
+		p.event.Skipped++
+	} else {
+		p.event.Nodes++
+	}
+	p.event.Depth = depth
+	p.report(p.event)
+}
+
+// addBytes records the size of a fetched node, along with the estimated size
+// of the DAG if it is the root.
+func (p *walkProgress) addBytes(size int, total uint64) {
+	if p == nil {
+		return
+	}
+	p.lk.Lock()
+	p.event.Bytes += uint64(size)
+	if total > 0 {
+		p.event.TotalBytes = total
+	}
+	p.lk.Unlock()
+}
@@ -0,0 +1,69 @@
+package merkledag_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestFetchGraphProgress(t *testing.T) {
+	ctx := context.Background()
+	dserv := dstest.Mock()
+	root := makeTree(t, dserv, 3, 3, "root")
+	all := cid.NewSet()
+	if err := Walk(ctx, GetLinksDirect(dserv), root.Cid(), all.Visit); err != nil {
+		t.Fatal(err)
+	}
+	size, err := root.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []ProgressEvent
+	if err := FetchGraph(ctx, root.Cid(), dserv, OnProgress(func(ev ProgressEvent) {
+		events = append(events, ev)
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != all.Len() {
+		t.Fatalf("expected %d events, got %d", all.Len(), len(events))
+	}
+	for i, ev := range events {
+		if ev.Nodes != i+1 || ev.TotalBytes != size || ev.InFlight < 0 || ev.Depth > 3 {
+			t.Fatalf("unexpected event %d: %+v", i, ev)
+		}
+	}
+	last := events[len(events)-1]
+	if last.Bytes != size || last.InFlight != 0 || last.Skipped != 0 {
+		t.Fatalf("unexpected last event: %+v", last)
+	}
+}
+
+func TestWalkProgress(t *testing.T) {
+	ctx := context.Background()
+	dserv := dstest.Mock()
+	root := makeTree(t, dserv, 2, 3, "root")
+	missing := root.Links()[1].Cid
+	if err := dserv.Remove(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, concurrency := range []int{1, 4} {
+		var last ProgressEvent
+		err := Walk(ctx, GetLinksDirect(dserv), root.Cid(), cid.NewSet().Visit, Concurrency(concurrency), IgnoreMissing(), OnProgress(func(ev ProgressEvent) {
+			last = ev
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the missing node had 3 children
+		expect := ProgressEvent{Nodes: 1 + 2 + 2*3, Skipped: 1, Depth: last.Depth}
+		if last != expect {
+			t.Fatalf("concurrency %d: expected %+v, got %+v", concurrency, expect, last)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package merkledag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsns "github.com/ipfs/go-datastore/namespace"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// BatchDeleter is implemented by blockservices and blockstores which can
+// delete many blocks at once. dagService.RemoveMany uses it when either its
+// blockservice or the blockservice's blockstore implements it.
+//
+// DeleteBlocks should return a *RemoveError when only some of the blocks
+// could not be deleted.
+type BatchDeleter interface {
+	DeleteBlocks(ctx context.Context, cids []cid.Cid) error
+}
+
+// RemoveFailure records why a node could not be removed.
+type RemoveFailure struct {
+	Cid cid.Cid
+	Err error
+}
+
+// RemoveError is returned by RemoveMany when some of the nodes could not be
+// removed. The other nodes were removed.
+type RemoveError struct {
+	Failures []RemoveFailure
+}
+
+func (e *RemoveError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "failed to remove %d node(s)", len(e.Failures))
+	for i, f := range e.Failures {
+		if i == 3 {
+			fmt.Fprintf(&sb, "; and %d more", len(e.Failures)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "; %s: %s", f.Cid, f.Err)
+	}
+	return sb.String()
+}
+
+// Unwrap returns the errors of every failure.
+func (e *RemoveError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// NewBatchDeletingBlockstore returns a blockstore over d, laid out like the
+// one returned by blockstore.NewBlockstore, which also implements
+// BatchDeleter by deleting blocks through a single datastore batch.
+//
+// Note that wrapping the returned blockstore (e.g. with the caching
+// blockstores) hides the BatchDeleter implementation.
+func NewBatchDeletingBlockstore(d ds.Batching) blockstore.Blockstore {
+	return &batchDeletingBlockstore{
+		Blockstore: blockstore.NewBlockstore(d),
+		datastore:  dsns.Wrap(d, blockstore.BlockPrefix),
+	}
+}
+
+type batchDeletingBlockstore struct {
+	blockstore.Blockstore
+	datastore ds.Batching
+}
+
+// DeleteBlocks deletes the given blocks in a single datastore batch. If the
+// batch fails to commit, none of the blocks may have been deleted.
+func (bs *batchDeletingBlockstore) DeleteBlocks(ctx context.Context, cids []cid.Cid) error {
+	b, err := bs.datastore.Batch(ctx)
+	if err != nil {
+		return err
+	}
+
+	var failures []RemoveFailure
+	for _, c := range cids {
+		if err := b.Delete(ctx, dshelp.MultihashToDsKey(c.Hash())); err != nil {
+			failures = append(failures, RemoveFailure{Cid: c, Err: err})
+		}
+	}
+
+	if err := b.Commit(ctx); err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		return &RemoveError{Failures: failures}
+	}
+	return nil
+}
+
+var _ BatchDeleter = (*batchDeletingBlockstore)(nil)
+
+// RemoveDAGOptions specifies the behavior of RemoveDAG.
+type RemoveDAGOptions struct {
+	// Keep lists the roots of DAGs which must be preserved: nodes reachable
+	// from any of them are not removed.
+	Keep []cid.Cid
+	// DryRun reports the nodes which would be removed without removing them.
+	DryRun bool
+}
+
+// RemoveDAGResult describes the nodes removed by RemoveDAG.
+type RemoveDAGResult struct {
+	// Removed lists the removed nodes.
+	Removed []cid.Cid
+	// Size is the total size of the removed blocks, in bytes.
+	Size uint64
+}
+
+// RemoveDAG removes root and all its descendants from serv, except for the
+// nodes reachable from the roots in opts.Keep.
+//
+// The DAGs of the kept roots must be complete: if any of their nodes is
+// missing, RemoveDAG can't tell what they reference and fails without
+// removing anything. Nodes of the removed DAG which are already missing are
+// skipped, and so are their descendants unless reachable through other
+// nodes.
+//
+// When serv is a DAGService of this package, nodes are only read from its
+// local blockstore, so that missing nodes are never fetched.
+//
+// If removal fails, the returned result still lists the nodes RemoveDAG
+// attempted to remove, and the error is the one from serv.RemoveMany.
+func RemoveDAG(ctx context.Context, root cid.Cid, serv format.DAGService, opts RemoveDAGOptions) (*RemoveDAGResult, error) {
+	local := localDAG(serv)
+	keep := cid.NewSet()
+	for _, k := range opts.Keep {
+		if keep.Has(k) {
+			continue
+		}
+		if err := Walk(ctx, GetLinksWithDAG(local), k, keep.Visit, Concurrent()); err != nil {
+			return nil, fmt.Errorf("failed to walk kept DAG %s: %w", k, err)
+		}
+	}
+
+	res := new(RemoveDAGResult)
+	var lk sync.Mutex
+
+	seen := cid.NewSet()
+	visit := func(c cid.Cid) bool {
+		return !keep.Has(c) && seen.Visit(c)
+	}
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*format.Link, error) {
+		nd, err := local.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+
+		lk.Lock()
+		res.Removed = append(res.Removed, c)
+		res.Size += uint64(len(nd.RawData()))
+		lk.Unlock()
+
+		return nd.Links(), nil
+	}
+
+	if err := Walk(ctx, getLinks, root, visit, Concurrent(), IgnoreMissing()); err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun || len(res.Removed) == 0 {
+		return res, nil
+	}
+	return res, serv.RemoveMany(ctx, res.Removed)
+}
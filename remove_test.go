@@ -0,0 +1,202 @@
+package merkledag_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// failingDeleteBlockService fails to delete one given block.
+type failingDeleteBlockService struct {
+	bserv.BlockService
+	fail cid.Cid
+}
+
+var errDeleteFailed = errors.New("delete failed")
+
+func (bs *failingDeleteBlockService) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	if c.Equals(bs.fail) {
+		return errDeleteFailed
+	}
+	return bs.BlockService.DeleteBlock(ctx, c)
+}
+
+func addRawNodes(t *testing.T, dserv ipld.DAGService, n int) []cid.Cid {
+	ctx := context.Background()
+	var cids []cid.Cid
+	for i := 0; i < n; i++ {
+		nd := NewRawNode([]byte(fmt.Sprint("node ", i)))
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, nd.Cid())
+	}
+	return cids
+}
+
+func TestRemoveManyBatched(t *testing.T) {
+	ctx := context.Background()
+	bstore := NewBatchDeletingBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	if _, ok := bstore.(BatchDeleter); !ok {
+		t.Fatal("blockstore should implement BatchDeleter")
+	}
+	dserv := NewDAGService(bserv.New(bstore, offline.Exchange(bstore)))
+
+	cids := addRawNodes(t, dserv, 10)
+	if err := dserv.RemoveMany(ctx, cids[:7]); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range cids {
+		_, err := dserv.Get(ctx, c)
+		if i < 7 && !ipld.IsNotFound(err) {
+			t.Fatalf("node %d should have been removed, got %v", i, err)
+		}
+		if i >= 7 && err != nil {
+			t.Fatalf("node %d should still exist, got %v", i, err)
+		}
+	}
+}
+
+func TestRemoveManyPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	bs := &failingDeleteBlockService{BlockService: dstest.Bserv()}
+	dserv := NewDAGService(bs)
+
+	cids := addRawNodes(t, dserv, 5)
+	bs.fail = cids[1]
+
+	err := dserv.RemoveMany(ctx, cids)
+	var rerr *RemoveError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected a RemoveError, got %v", err)
+	}
+	if len(rerr.Failures) != 1 || !rerr.Failures[0].Cid.Equals(cids[1]) {
+		t.Fatalf("unexpected failures: %v", rerr.Failures)
+	}
+	if !errors.Is(err, errDeleteFailed) {
+		t.Fatal("RemoveError should wrap the underlying error")
+	}
+
+	// every other node was still removed
+	for i, c := range cids {
+		_, err := dserv.Get(ctx, c)
+		if i == 1 && err != nil {
+			t.Fatal(err)
+		}
+		if i != 1 && !ipld.IsNotFound(err) {
+			t.Fatalf("node %d should have been removed, got %v", i, err)
+		}
+	}
+}
+
+func TestRemoveDAG(t *testing.T) {
+	ctx := context.Background()
+	dserv := dstest.Mock()
+	root := makeDepthTestingGraph(t, dserv)
+
+	// keep the first child of the root, and everything below it
+	kept := root.Links()[0].Cid
+	keepSet := cid.NewSet()
+	if err := Walk(ctx, GetLinksDirect(dserv), kept, keepSet.Visit); err != nil {
+		t.Fatal(err)
+	}
+	all := cid.NewSet()
+	if err := Walk(ctx, GetLinksDirect(dserv), root.Cid(), all.Visit); err != nil {
+		t.Fatal(err)
+	}
+	expected := all.Len() - keepSet.Len()
+
+	opts := RemoveDAGOptions{Keep: []cid.Cid{kept}, DryRun: true}
+	res, err := RemoveDAG(ctx, root.Cid(), dserv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Removed) != expected {
+		t.Fatalf("expected %d nodes to be removed, got %d", expected, len(res.Removed))
+	}
+	var size uint64
+	for _, c := range res.Removed {
+		if keepSet.Has(c) {
+			t.Fatalf("kept node %s would be removed", c)
+		}
+		nd, err := dserv.Get(ctx, c)
+		if err != nil {
+			t.Fatal("dry run removed a node")
+		}
+		size += uint64(len(nd.RawData()))
+	}
+	if res.Size != size {
+		t.Fatalf("expected size %d, got %d", size, res.Size)
+	}
+
+	opts.DryRun = false
+	if _, err := RemoveDAG(ctx, root.Cid(), dserv, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range all.Keys() {
+		_, err := dserv.Get(ctx, c)
+		if keepSet.Has(c) && err != nil {
+			t.Fatalf("kept node %s was removed: %v", c, err)
+		}
+		if !keepSet.Has(c) && !ipld.IsNotFound(err) {
+			t.Fatalf("node %s should have been removed, got %v", c, err)
+		}
+	}
+
+	// running again only finds the missing root
+	res, err = RemoveDAG(ctx, root.Cid(), dserv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Removed) != 0 {
+		t.Fatalf("expected nothing to remove, got %d nodes", len(res.Removed))
+	}
+}
+
+func TestRemoveDAGOnline(t *testing.T) {
+	ctx := context.Background()
+	full := dstest.Bserv()
+	root := makeDepthTestingGraph(t, NewDAGService(full))
+	missing := root.Links()[0].Cid
+
+	// dst could fetch its missing node from full
+	dstore := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	dst := NewDAGService(bserv.New(dstore, offline.Exchange(full.Blockstore())))
+	if err := FetchGraph(ctx, root.Cid(), dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := dstore.DeleteBlock(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+
+	// kept DAGs must be complete locally
+	_, err := RemoveDAG(ctx, root.Cid(), dst, RemoveDAGOptions{Keep: []cid.Cid{root.Cid()}, DryRun: true})
+	if !ipld.IsNotFound(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	res, err := RemoveDAG(ctx, root.Cid(), dst, RemoveDAGOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range res.Removed {
+		if c.Equals(missing) {
+			t.Fatal("missing node would be removed")
+		}
+	}
+	if has, _ := dstore.Has(ctx, missing); has {
+		t.Fatal("missing node was fetched")
+	}
+}
@@ -0,0 +1,115 @@
+package merkledag
+
+import (
+	"context"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// WalkReport summarizes a walk, such as the nodes an error handler skipped,
+// to find which nodes to fetch again.
+type WalkReport struct {
+	lk sync.Mutex
+
+	// Visited is the number of nodes accepted by the visit function.
+	Visited int
+	// MaxDepth is the greatest depth of the visited nodes.
+	MaxDepth int
+	// Duplicates is the number of times the visit function rejected a
+	// node, because it was already visited or, for FetchGraph, beyond the
+	// depth limit.
+	Duplicates int
+
+	// Missing holds the nodes which were not found, and skipped by an error
+	// handler such as IgnoreMissing.
+	Missing []cid.Cid
+	// Skipped holds the nodes which failed to be fetched for other reasons,
+	// and were skipped by an error handler, with their error.
+	Skipped map[cid.Cid]error
+}
+
+// WalkWithReport is like Walk, but also returns a report of the walk. The
+// report covers the part of the DAG which was walked if an error is
+// returned.
+func WalkWithReport(ctx context.Context, getLinks GetLinks, c cid.Cid, visit func(cid.Cid) bool, options ...WalkOption) (*WalkReport, error) {
+	report := new(WalkReport)
+	err := Walk(ctx, getLinks, c, visit, append(options, report.option)...)
+	return report, err
+}
+
+// WalkDepthWithReport is like WalkDepth, but also returns a report of the
+// walk. The report covers the part of the DAG which was walked if an error
+// is returned.
+func WalkDepthWithReport(ctx context.Context, getLinks GetLinks, c cid.Cid, visit func(cid.Cid, int) bool, options ...WalkOption) (*WalkReport, error) {
+	report := new(WalkReport)
+	err := WalkDepth(ctx, getLinks, c, visit, append(options, report.option)...)
+	return report, err
+}
+
+// FetchGraphWithReport is like FetchGraph, but also returns a report of the
+// fetch. The report covers the part of the DAG which was fetched if an
+// error is returned.
+func FetchGraphWithReport(ctx context.Context, root cid.Cid, serv format.DAGService, options ...WalkOption) (*WalkReport, error) {
+	report := new(WalkReport)
+	err := FetchGraph(ctx, root, serv, append(options, report.option)...)
+	return report, err
+}
+
+// option is the WalkOption recording the walk in the report.
+func (r *WalkReport) option(walkOptions *walkOptions) {
+	walkOptions.report = r
+}
+
+// reporting wraps the error handler of the walk to record the skipped
+// nodes in its report, if any. Errors can't be skipped without a handler.
+func (wo *walkOptions) reporting() {
+	r := wo.report
+	handler := wo.ErrorHandler
+	if r == nil || handler == nil {
+		return
+	}
+	wo.ErrorHandler = func(c cid.Cid, err error) error {
+		handled := handler(c, err)
+		if handled == nil {
+			r.skipped(c, err)
+		}
+		return handled
+	}
+}
+
+func (r *WalkReport) skipped(c cid.Cid, err error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if format.IsNotFound(err) {
+		r.Missing = append(r.Missing, c)
+		return
+	}
+	if r.Skipped == nil {
+		r.Skipped = make(map[cid.Cid]error)
+	}
+	r.Skipped[c] = err
+}
+
+// visiting wraps visit to record the visits in the report. It returns visit
+// on a nil report.
+func (r *WalkReport) visiting(visit func(cid.Cid, int) bool) func(cid.Cid, int) bool {
+	if r == nil {
+		return visit
+	}
+	return func(c cid.Cid, depth int) bool {
+		ok := visit(c, depth)
+
+		r.lk.Lock()
+		if ok {
+			r.Visited++
+			r.MaxDepth = max(r.MaxDepth, depth)
+		} else {
+			r.Duplicates++
+		}
+		r.lk.Unlock()
+		return ok
+	}
+}
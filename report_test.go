@@ -0,0 +1,74 @@
+package merkledag_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func TestWalkReport(t *testing.T) {
+	ctx := context.Background()
+	dserv := dstest.Mock()
+	sub := makeTree(t, dserv, 2, 3, "sub")
+	root := NodeWithData([]byte("root"))
+	for _, name := range []string{"a", "b"} {
+		if err := root.AddNodeLink(name, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dserv.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := sub.Links()[0].Cid
+	if err := dserv.Remove(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+	failing := sub.Links()[1].Cid
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		if c == failing {
+			return nil, errFlaky
+		}
+		return GetLinksDirect(dserv)(ctx, c)
+	}
+	skipAll := OnError(func(cid.Cid, error) error { return nil })
+
+	for _, concurrency := range []int{1, 4} {
+		report, err := WalkWithReport(ctx, getLinks, root.Cid(), cid.NewSet().Visit, Concurrency(concurrency), skipAll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the root, sub, its 3 children and the 3 children of the last one
+		if report.Visited != 8 || report.MaxDepth != 3 || report.Duplicates != 1 {
+			t.Fatalf("concurrency %d: unexpected report %+v", concurrency, report)
+		}
+		if len(report.Missing) != 1 || report.Missing[0] != missing {
+			t.Fatalf("concurrency %d: expected %s to be missing, got %v", concurrency, missing, report.Missing)
+		}
+		if len(report.Skipped) != 1 || report.Skipped[failing] != errFlaky {
+			t.Fatalf("concurrency %d: expected %s to be skipped, got %v", concurrency, failing, report.Skipped)
+		}
+	}
+
+	// errors which are not skipped are not reported
+	report, err := WalkDepthWithReport(ctx, getLinks, root.Cid(), func(cid.Cid, int) bool { return true }, IgnoreMissing())
+	if err != errFlaky {
+		t.Fatalf("expected %v, got %v", errFlaky, err)
+	}
+	if len(report.Missing) != 1 || len(report.Skipped) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	report, err = FetchGraphWithReport(ctx, root.Cid(), dserv, IgnoreMissing())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Visited != 11 || report.Duplicates != 1 || len(report.Missing) != 1 || report.Missing[0] != missing {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
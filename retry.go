@@ -0,0 +1,140 @@
+package merkledag
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// Default values of the zero fields of a RetryPolicy.
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 10 * time.Second
+)
+
+// RetryPolicy configures how Retry fetches the links of a node. Zero fields
+// take default values, except for Timeout and Jitter.
+type RetryPolicy struct {
+	// Timeout is the deadline of each attempt. Zero means no deadline.
+	Timeout time.Duration
+	// Attempts is the maximum number of attempts for each node, 3 by
+	// default.
+	Attempts int
+	// Backoff is the delay before the first retry, 100ms by default. It
+	// doubles after each retry, up to MaxBackoff, 10s by default.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter is the fraction of each delay which is randomized, between 0
+	// and 1, so walks failing together don't retry together.
+	Jitter float64
+	// Retryable reports whether an error is transient. By default, all
+	// errors are, except for missing nodes.
+	Retryable func(error) bool
+}
+
+// RetryReport records the retries of a walk using Retry. It may be read
+// once the walk returned.
+type RetryReport struct {
+	lk sync.Mutex
+
+	// Retries is the number of failed attempts which were retried.
+	Retries int
+	// Exhausted holds the nodes which failed every attempt, with their last
+	// error.
+	Exhausted map[cid.Cid]error
+}
+
+func (r *RetryReport) retried() {
+	if r == nil {
+		return
+	}
+	r.lk.Lock()
+	r.Retries++
+	r.lk.Unlock()
+}
+
+func (r *RetryReport) exhausted(c cid.Cid, err error) {
+	if r == nil {
+		return
+	}
+	r.lk.Lock()
+	if r.Exhausted == nil {
+		r.Exhausted = make(map[cid.Cid]error)
+	}
+	r.Exhausted[c] = err
+	r.lk.Unlock()
+}
+
+// Retry is a WalkOption fetching the links of every node with a deadline,
+// and retrying transient failures with an exponential backoff. The nodes
+// which failed every attempt are recorded in report if it is not nil, and
+// their last error is handled like any other: it aborts the walk, unless an
+// error handler such as IgnoreErrors skips it.
+func Retry(policy RetryPolicy, report *RetryReport) WalkOption {
+	if policy.Attempts <= 0 {
+		policy.Attempts = defaultRetryAttempts
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = defaultRetryBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = func(err error) bool {
+			return !format.IsNotFound(err)
+		}
+	}
+	return func(walkOptions *walkOptions) {
+		walkOptions.RetryPolicy = &policy
+		walkOptions.RetryReport = report
+	}
+}
+
+// retrying wraps getLinks to apply the RetryPolicy of the walk, if any.
+func (wo *walkOptions) retrying(getLinks GetLinks) GetLinks {
+	p := wo.RetryPolicy
+	if p == nil {
+		return getLinks
+	}
+	report := wo.RetryReport
+
+	return func(ctx context.Context, c cid.Cid) ([]*format.Link, error) {
+		delay := p.Backoff
+		for attempt := 1; ; attempt++ {
+			links, err := p.attempt(ctx, getLinks, c)
+			if err == nil || ctx.Err() != nil || !p.Retryable(err) {
+				return links, err
+			}
+			if attempt >= p.Attempts {
+				report.exhausted(c, err)
+				return nil, err
+			}
+			report.retried()
+
+			wait := delay - time.Duration(p.Jitter*rand.Float64()*float64(delay))
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+			delay = min(2*delay, p.MaxBackoff)
+		}
+	}
+}
+
+func (p *RetryPolicy) attempt(ctx context.Context, getLinks GetLinks, c cid.Cid) ([]*format.Link, error) {
+	if p.Timeout <= 0 {
+		return getLinks(ctx, c)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	return getLinks(ctx, c)
+}
@@ -0,0 +1,109 @@
+package merkledag_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+var errFlaky = errors.New("flaky fetch")
+
+// flakyDAG fails the first fetches of every node, and never returns stuck
+// nodes until the context is done.
+type flakyDAG struct {
+	ipld.DAGService
+	failures int
+	stuck    cid.Cid
+
+	lk       sync.Mutex
+	attempts map[cid.Cid]int
+}
+
+func (d *flakyDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	d.lk.Lock()
+	d.attempts[c]++
+	attempt := d.attempts[c]
+	d.lk.Unlock()
+
+	if c == d.stuck {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if attempt <= d.failures {
+		return nil, errFlaky
+	}
+	return d.DAGService.Get(ctx, c)
+}
+
+func TestWalkRetry(t *testing.T) {
+	ctx := context.Background()
+	src := dstest.Mock()
+	root := makeTree(t, src, 2, 3, "root")
+	nodes := 1 + 3 + 9
+
+	dserv := &flakyDAG{DAGService: src, failures: 2, attempts: make(map[cid.Cid]int)}
+	var report RetryReport
+	policy := RetryPolicy{Backoff: time.Millisecond, Jitter: 0.5}
+	if err := FetchGraph(ctx, root.Cid(), dserv, Retry(policy, &report)); err != nil {
+		t.Fatal(err)
+	}
+	if report.Retries != 2*nodes || len(report.Exhausted) != 0 {
+		t.Fatalf("expected %d retries and no exhausted node, got %d and %d", 2*nodes, report.Retries, len(report.Exhausted))
+	}
+
+	// without enough attempts, the error is handled as usual
+	dserv = &flakyDAG{DAGService: src, failures: 2, attempts: make(map[cid.Cid]int)}
+	report = RetryReport{}
+	policy.Attempts = 2
+	if err := FetchGraph(ctx, root.Cid(), dserv, Retry(policy, &report)); err != errFlaky {
+		t.Fatalf("expected %v, got %v", errFlaky, err)
+	}
+	if report.Exhausted[root.Cid()] != errFlaky {
+		t.Fatalf("expected the root to be exhausted, got %v", report.Exhausted)
+	}
+}
+
+func TestWalkRetryTimeout(t *testing.T) {
+	ctx := context.Background()
+	src := dstest.Mock()
+	root := makeTree(t, src, 2, 3, "root")
+	stuck := root.Links()[1].Cid
+
+	dserv := &flakyDAG{DAGService: src, stuck: stuck, attempts: make(map[cid.Cid]int)}
+	var report RetryReport
+	policy := RetryPolicy{Timeout: 10 * time.Millisecond, Attempts: 2, Backoff: time.Millisecond}
+	if err := FetchGraph(ctx, root.Cid(), dserv, Retry(policy, &report), IgnoreErrors()); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Exhausted) != 1 || report.Exhausted[stuck] != context.DeadlineExceeded {
+		t.Fatalf("expected %s to be exhausted, got %v", stuck, report.Exhausted)
+	}
+	if dserv.attempts[stuck] != 2 || report.Retries != 1 {
+		t.Fatalf("expected 2 attempts and 1 retry, got %d and %d", dserv.attempts[stuck], report.Retries)
+	}
+	// the children of the stuck node are the only ones left out
+	if len(dserv.attempts) != 1+3+6 {
+		t.Fatalf("expected 10 nodes to be fetched, got %d", len(dserv.attempts))
+	}
+
+	// missing nodes are not retried
+	if err := src.Remove(ctx, stuck); err != nil {
+		t.Fatal(err)
+	}
+	report = RetryReport{}
+	err := Walk(ctx, GetLinksDirect(src), root.Cid(), cid.NewSet().Visit, Retry(policy, &report))
+	if !ipld.IsNotFound(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+	if report.Retries != 0 || len(report.Exhausted) != 0 {
+		t.Fatalf("expected no retry, got %d retries and %d exhausted nodes", report.Retries, len(report.Exhausted))
+	}
+}
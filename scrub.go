@@ -0,0 +1,350 @@
+package merkledag
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	legacy "github.com/ipfs/go-ipld-legacy"
+)
+
+// FindingKind is the kind of problem reported by a Finding.
+type FindingKind int
+
+const (
+	// FindingMissing is a node whose block is not found.
+	FindingMissing FindingKind = iota
+	// FindingHashMismatch is a node whose block doesn't match its CID.
+	FindingHashMismatch
+	// FindingUndecodable is a node whose block can't be decoded.
+	FindingUndecodable
+	// FindingLinkOrder is a dag-pb node whose links are not sorted by name,
+	// as canonical dag-pb requires.
+	FindingLinkOrder
+	// FindingLinkSize is a dag-pb link whose size is not the cumulative
+	// size of the linked node.
+	FindingLinkSize
+)
+
+func (k FindingKind) String() string {
+	switch k {
+	case FindingMissing:
+		return "missing"
+	case FindingHashMismatch:
+		return "hash mismatch"
+	case FindingUndecodable:
+		return "undecodable"
+	case FindingLinkOrder:
+		return "link order"
+	case FindingLinkSize:
+		return "link size"
+	default:
+		return "unknown"
+	}
+}
+
+// Finding is a problem found by Verify.
+type Finding struct {
+	Kind FindingKind
+	// Cid is the node the problem was found in.
+	Cid cid.Cid
+	// Err is the error reading or decoding the block of the node, for
+	// FindingMissing, FindingHashMismatch and FindingUndecodable.
+	Err error
+
+	// Link is the first link out of order for FindingLinkOrder, and the link
+	// with a wrong size for FindingLinkSize.
+	Link *format.Link
+	// Size is the actual cumulative size of the linked node, for
+	// FindingLinkSize: the size of its block plus the cumulative sizes of its
+	// children.
+	Size uint64
+
+	// Repaired is set if the Repair function replaced the block of the node.
+	Repaired bool
+	// RepairErr is the error returned by the Repair function, if any.
+	RepairErr error
+}
+
+// RepairFunc repairs the block of the node a Finding is about, returning the
+// node to use in place of the faulty block. It returns a nil node if it
+// cannot repair the node.
+type RepairFunc func(ctx context.Context, f Finding) (format.Node, error)
+
+// VerifyOptions configure Verify.
+type VerifyOptions struct {
+	// Blocks is the blockservice, or session, to read the blocks from.
+	Blocks bserv.BlockGetter
+	// Decoder decodes the blocks, the global decoder if nil. See NewDecoder.
+	Decoder *legacy.Decoder
+
+	// OnFinding, if set, is called with each finding once it is repaired,
+	// or failed to be. It is never called concurrently.
+	OnFinding func(Finding)
+	// Repair, if set, is called with the findings about the block of a node:
+	// FindingMissing, FindingHashMismatch and FindingUndecodable. The links
+	// of the nodes it returns are verified in place of the faulty ones. See
+	// RefetchFrom.
+	Repair RepairFunc
+
+	// SkipLinkSizes disables checking the sizes of the dag-pb links, which
+	// keeps the cumulative size of every node in memory.
+	SkipLinkSizes bool
+	// Visited records the verified nodes, in memory if nil. With
+	// SkipLinkSizes, a set not kept in memory allows verifying DAGs too large
+	// to track in memory.
+	Visited VisitedSet
+	// WalkOptions configure the walk, such as its Concurrency.
+	WalkOptions []WalkOption
+}
+
+// Verify walks the DAG under root, and checks for every node that its block
+// is present, matches its CID and can be decoded. For dag-pb nodes, it also
+// checks that the links are sorted, and that their sizes are the cumulative
+// sizes of the linked nodes. Each problem is reported as a Finding.
+//
+// The children of nodes which could not be read, nor repaired, are not
+// walked, and the sizes of the links to those nodes and their ancestors are
+// not checked. The returned error is the one which aborted the walk, if any:
+// the findings are returned either way.
+func Verify(ctx context.Context, root cid.Cid, opts VerifyOptions) ([]Finding, error) {
+	if opts.Blocks == nil {
+		return nil, errors.New("no blockservice to verify")
+	}
+	s := &scrubber{
+		opts:    opts,
+		decoder: opts.Decoder,
+		sizes:   make(map[cid.Cid]uint64),
+		pending: make(map[cid.Cid]*pendingSize),
+		links:   make(map[cid.Cid][]sizedLink),
+	}
+	if s.decoder == nil {
+		s.decoder = ipldLegacyDecoder
+	}
+
+	visited := opts.Visited
+	if visited == nil {
+		visited = NewMemoryVisitedSet()
+	}
+	// every node is verified once, whatever its depth
+	visit := func(ctx context.Context, c cid.Cid, _ int) (bool, error) {
+		return visited.Visit(ctx, c, 0)
+	}
+	err := walkFallible(ctx, visit, func(ctx context.Context, visit func(cid.Cid, int) bool) error {
+		return WalkDepth(ctx, s.getLinks, root, visit, opts.WalkOptions...)
+	})
+	return s.findings, err
+}
+
+// RefetchFrom returns a RepairFunc fetching the faulty nodes from secondary,
+// checking their hash, and adding them to dst. Faulty blocks are removed
+// from dst first, as blockstores don't overwrite the blocks they have.
+func RefetchFrom(secondary format.NodeGetter, dst format.DAGService) RepairFunc {
+	return func(ctx context.Context, f Finding) (format.Node, error) {
+		nd, err := secondary.Get(ctx, f.Cid)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyData(VerifyAll, f.Cid, nd.RawData()); err != nil {
+			return nil, err
+		}
+		if f.Kind != FindingMissing {
+			if err := dst.Remove(ctx, f.Cid); err != nil {
+				return nil, err
+			}
+		}
+		if err := dst.Add(ctx, nd); err != nil {
+			return nil, err
+		}
+		return nd, nil
+	}
+}
+
+// sizedLink is a dag-pb link, whose size is checked once the cumulative size
+// of the linked node is known.
+type sizedLink struct {
+	parent cid.Cid
+	link   *format.Link
+}
+
+// pendingSize is the cumulative size of a dag-pb node, known once the sizes
+// of all its children are.
+type pendingSize struct {
+	size uint64
+	left int
+}
+
+type scrubber struct {
+	opts    VerifyOptions
+	decoder *legacy.Decoder
+
+	lk       sync.Mutex
+	findings []Finding
+	// sizes holds the cumulative sizes of the nodes whose whole DAG was
+	// loaded, pending the partial sizes of the dag-pb nodes waiting for the
+	// sizes of their children, and links the links to those children.
+	sizes   map[cid.Cid]uint64
+	pending map[cid.Cid]*pendingSize
+	links   map[cid.Cid][]sizedLink
+}
+
+func (s *scrubber) report(f Finding) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.findings = append(s.findings, f)
+	if s.opts.OnFinding != nil {
+		s.opts.OnFinding(f)
+	}
+}
+
+func (s *scrubber) getLinks(ctx context.Context, c cid.Cid) ([]*format.Link, error) {
+	nd, err := s.load(ctx, c)
+	if err != nil || nd == nil {
+		return nil, err
+	}
+
+	links := nd.Links()
+	if pn, ok := nd.(*ProtoNode); ok {
+		s.checkOrder(pn, links)
+	}
+	if !s.opts.SkipLinkSizes {
+		if err := s.checkSizes(c, nd, links); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+// load reads and decodes the node c, repairing it if needed. It returns a
+// nil node if it could not.
+func (s *scrubber) load(ctx context.Context, c cid.Cid) (format.Node, error) {
+	blk, err := s.opts.Blocks.GetBlock(ctx, c)
+	if err != nil {
+		if !format.IsNotFound(err) {
+			return nil, err
+		}
+		return s.repair(ctx, Finding{Kind: FindingMissing, Cid: c, Err: err})
+	}
+	if err := verifyData(VerifyAll, c, blk.RawData()); err != nil {
+		return s.repair(ctx, Finding{Kind: FindingHashMismatch, Cid: c, Err: err})
+	}
+	nd, err := s.decoder.DecodeNode(ctx, blk)
+	if err != nil {
+		return s.repair(ctx, Finding{Kind: FindingUndecodable, Cid: c, Err: err})
+	}
+	return nd, nil
+}
+
+// repair tries to repair the node of f, and reports f.
+func (s *scrubber) repair(ctx context.Context, f Finding) (format.Node, error) {
+	var nd format.Node
+	if s.opts.Repair != nil {
+		nd, f.RepairErr = s.opts.Repair(ctx, f)
+		f.Repaired = nd != nil && f.RepairErr == nil
+	}
+	s.report(f)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !f.Repaired {
+		return nil, nil
+	}
+	return nd, nil
+}
+
+// checkOrder checks that the links of a dag-pb node are sorted by name.
+func (s *scrubber) checkOrder(nd *ProtoNode, links []*format.Link) {
+	for i := 1; i < len(links); i++ {
+		if links[i].Name < links[i-1].Name {
+			s.report(Finding{Kind: FindingLinkOrder, Cid: nd.Cid(), Link: links[i]})
+			return
+		}
+	}
+}
+
+// checkSizes checks the sizes of the links of the node c whose children are
+// sized, and records the others to check them once their children are.
+//
+// The cumulative size of a dag-pb node is the size of its block plus the
+// cumulative sizes of its children, and is only known once they are all
+// loaded. That of other nodes is their size.
+func (s *scrubber) checkSizes(c cid.Cid, nd format.Node, links []*format.Link) error {
+	pn, ok := nd.(*ProtoNode)
+	if !ok {
+		size, err := nd.Size()
+		if err != nil {
+			return err
+		}
+		s.lk.Lock()
+		found := s.resolve(c, size)
+		s.lk.Unlock()
+		s.reportAll(found)
+		return nil
+	}
+
+	var found []Finding
+	p := &pendingSize{size: uint64(len(pn.RawData()))}
+	s.lk.Lock()
+	for _, l := range links {
+		size, sized := s.sizes[l.Cid]
+		if !sized {
+			s.links[l.Cid] = append(s.links[l.Cid], sizedLink{parent: c, link: l})
+			p.left++
+			continue
+		}
+		p.size += size
+		if l.Size != size {
+			found = append(found, Finding{Kind: FindingLinkSize, Cid: c, Link: l, Size: size})
+		}
+	}
+	if p.left == 0 {
+		found = append(found, s.resolve(c, p.size)...)
+	} else {
+		s.pending[c] = p
+	}
+	s.lk.Unlock()
+	s.reportAll(found)
+	return nil
+}
+
+// resolve records the cumulative size of c, checks the links waiting for it,
+// and resolves the sizes of the nodes they complete. It returns the
+// findings. s.lk must be held.
+func (s *scrubber) resolve(c cid.Cid, size uint64) []Finding {
+	var found []Finding
+	type sized struct {
+		c    cid.Cid
+		size uint64
+	}
+	queue := []sized{{c, size}}
+	for len(queue) > 0 {
+		n := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		s.sizes[n.c] = n.size
+		for _, l := range s.links[n.c] {
+			if l.link.Size != n.size {
+				found = append(found, Finding{Kind: FindingLinkSize, Cid: l.parent, Link: l.link, Size: n.size})
+			}
+			p := s.pending[l.parent]
+			p.size += n.size
+			p.left--
+			if p.left == 0 {
+				delete(s.pending, l.parent)
+				queue = append(queue, sized{l.parent, p.size})
+			}
+		}
+		delete(s.links, n.c)
+	}
+	return found
+}
+
+func (s *scrubber) reportAll(findings []Finding) {
+	for _, f := range findings {
+		s.report(f)
+	}
+}
@@ -0,0 +1,206 @@
+package merkledag_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	mdpb "github.com/ipfs/go-merkledag/pb"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// addLink links nd to child, with the given size.
+func addLink(t *testing.T, nd *ProtoNode, name string, child cid.Cid, size uint64) {
+	if err := nd.AddRawLink(name, &ipld.Link{Cid: child, Size: size}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func findingKinds(findings []Finding) []string {
+	var out []string
+	for _, f := range findings {
+		out = append(out, fmt.Sprintf("%s %t", f.Kind, f.Repaired))
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestVerifyScrub(t *testing.T) {
+	ctx := context.Background()
+	bs := dstest.Bserv()
+	dserv := NewDAGService(bs)
+	secondary := dstest.Mock()
+
+	root := NodeWithData([]byte("root"))
+	good := makeTree(t, dserv, 1, 2, "good")
+	addLink(t, root, "a", good.Cid(), mustSize(t, good))
+
+	// missing, and available from the secondary DAG
+	missing := NodeWithData([]byte("missing"))
+	if err := secondary.Add(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+	addLink(t, root, "b", missing.Cid(), mustSize(t, missing))
+
+	// corrupted, and available from the secondary DAG
+	corrupted := NodeWithData([]byte("corrupted"))
+	if err := secondary.Add(ctx, corrupted); err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid(NodeWithData([]byte("garbage")).RawData(), corrupted.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.AddBlock(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	addLink(t, root, "c", corrupted.Cid(), mustSize(t, corrupted))
+
+	// undecodable
+	undecodable, err := V0CidPrefix().Sum([]byte{0xff, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err = blocks.NewBlockWithCid([]byte{0xff, 0xff}, undecodable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.AddBlock(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	addLink(t, root, "d", undecodable, 2)
+
+	// wrong link size
+	sized := NodeWithData([]byte("sized"))
+	if err := dserv.Add(ctx, sized); err != nil {
+		t.Fatal(err)
+	}
+	addLink(t, root, "e", sized.Cid(), mustSize(t, sized)+1)
+
+	// links out of order
+	name := func(s string) *string { return &s }
+	pbn := &mdpb.PBNode{Links: []*mdpb.PBLink{
+		{Name: name("z"), Hash: sized.Cid().Bytes(), Tsize: new(uint64)},
+		{Name: name("a"), Hash: sized.Cid().Bytes(), Tsize: new(uint64)},
+	}}
+	data, err := pbn.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unordered, err := V0CidPrefix().Sum(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err = blocks.NewBlockWithCid(data, unordered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.AddBlock(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	addLink(t, root, "f", unordered, uint64(len(data))+2*mustSize(t, sized))
+
+	if err := dserv.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, concurrency := range []int{1, 4} {
+		findings, err := Verify(ctx, root.Cid(), VerifyOptions{Blocks: bs, WalkOptions: []WalkOption{Concurrency(concurrency)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the two links of the unordered node have a wrong size
+		expect := []string{"hash mismatch false", "link order false", "link size false", "link size false", "link size false", "missing false", "undecodable false"}
+		if got := findingKinds(findings); !slices.Equal(got, expect) {
+			t.Fatalf("concurrency %d: expected %v, got %v", concurrency, expect, got)
+		}
+		for _, f := range findings {
+			if f.Kind == FindingLinkSize && f.Cid == root.Cid() && (f.Link.Cid != sized.Cid() || f.Size != mustSize(t, sized)) {
+				t.Fatalf("unexpected finding %+v", f)
+			}
+		}
+	}
+
+	var reported int
+	findings, err := Verify(ctx, root.Cid(), VerifyOptions{
+		Blocks:    bs,
+		Repair:    RefetchFrom(secondary, dserv),
+		OnFinding: func(Finding) { reported++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"hash mismatch true", "link order false", "link size false", "link size false", "link size false", "missing true", "undecodable false"}
+	if got := findingKinds(findings); !slices.Equal(got, expect) || reported != len(findings) {
+		t.Fatalf("expected %v, got %v and %d reported findings", expect, got, reported)
+	}
+
+	// the repaired nodes are fine
+	findings, err = Verify(ctx, root.Cid(), VerifyOptions{Blocks: bs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = []string{"link order false", "link size false", "link size false", "link size false", "undecodable false"}
+	if got := findingKinds(findings); !slices.Equal(got, expect) {
+		t.Fatalf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestVerifyCumulativeSizes(t *testing.T) {
+	ctx := context.Background()
+	bs := dstest.Bserv()
+	dserv := NewDAGService(bs)
+
+	// the size of mid is computed from its wrong link size, and so is the
+	// size of the link to it
+	leaf := NodeWithData(make([]byte, 12))
+	mid := NodeWithData([]byte("mid"))
+	addLink(t, mid, "leaf", leaf.Cid(), 1)
+	root := NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("mid", mid); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []ipld.Node{leaf, mid, root} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	midSize := uint64(len(mid.RawData())) + mustSize(t, leaf)
+	for _, concurrency := range []int{1, 4} {
+		findings, err := Verify(ctx, root.Cid(), VerifyOptions{Blocks: bs, WalkOptions: []WalkOption{Concurrency(concurrency)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(findings) != 2 {
+			t.Fatalf("concurrency %d: expected 2 findings, got %+v", concurrency, findings)
+		}
+		for _, f := range findings {
+			switch {
+			case f.Kind == FindingLinkSize && f.Cid == mid.Cid() && f.Size == mustSize(t, leaf):
+			case f.Kind == FindingLinkSize && f.Cid == root.Cid() && f.Size == midSize:
+			default:
+				t.Fatalf("concurrency %d: unexpected finding %+v", concurrency, f)
+			}
+		}
+
+		findings, err = Verify(ctx, root.Cid(), VerifyOptions{Blocks: bs, SkipLinkSizes: true, WalkOptions: []WalkOption{Concurrency(concurrency)}})
+		if err != nil || len(findings) != 0 {
+			t.Fatalf("concurrency %d: expected no findings, got %+v, %v", concurrency, findings, err)
+		}
+	}
+}
+
+func mustSize(t *testing.T, nd ipld.Node) uint64 {
+	size, err := nd.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return size
+}
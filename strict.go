@@ -0,0 +1,218 @@
+package merkledag
+
+import (
+	"fmt"
+	"io"
+
+	cid "github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	ipld "github.com/ipld/go-ipld-prime"
+)
+
+// ErrNonCanonical is returned by the strict dag-pb decoders for data which
+// is not in the canonical dag-pb form.
+type ErrNonCanonical struct {
+	// Offset is the offset in the data of the offending byte.
+	Offset int
+	// Reason describes what is wrong.
+	Reason string
+}
+
+func (e ErrNonCanonical) Error() string {
+	return fmt.Sprintf("non-canonical dag-pb at byte %d: %s", e.Offset, e.Reason)
+}
+
+// DecodeProtobufStrict is like DecodeProtobuf, but only accepts data in the
+// canonical dag-pb form: links sorted by name, without duplicate names
+// (empty names excepted) nor undefined CIDs, no unknown protobuf fields, and
+// fields in order, encoded with minimal varints. It fails with an
+// ErrNonCanonical otherwise.
+//
+// DecodeProtobuf accepts such data, and keeps the links as serialized.
+func DecodeProtobufStrict(encoded []byte) (*ProtoNode, error) {
+	if err := checkCanonical(encoded); err != nil {
+		return nil, err
+	}
+	return DecodeProtobuf(encoded)
+}
+
+// StrictDagPB returns a Codec overriding the dag-pb decoder with one only
+// accepting the canonical dag-pb form, as DecodeProtobufStrict does.
+func StrictDagPB() Codec {
+	return Codec{
+		Code:      cid.DagProtobuf,
+		Decode:    decodeStrict,
+		Prototype: dagpb.Type.PBNode,
+		Converter: ProtoNodeConverter,
+	}
+}
+
+func decodeStrict(na ipld.NodeAssembler, in io.Reader) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	if err := checkCanonical(data); err != nil {
+		return err
+	}
+	return dagpb.DecodeBytes(na, data)
+}
+
+// protobuf field tags of the dag-pb schema
+const (
+	tagNodeData  = 1<<3 | 2
+	tagNodeLinks = 2<<3 | 2
+	tagLinkHash  = 1<<3 | 2
+	tagLinkName  = 2<<3 | 2
+	tagLinkTsize = 3<<3 | 0
+)
+
+// pbScanner reads protobuf fields from data[off:end], reporting errors at
+// their offset in data.
+type pbScanner struct {
+	data []byte
+	off  int
+	end  int
+}
+
+func nonCanonical(off int, format string, args ...interface{}) error {
+	return ErrNonCanonical{Offset: off, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (s *pbScanner) varint() (uint64, error) {
+	start := s.off
+	var v uint64
+	for i := 0; ; i++ {
+		if s.off >= s.end {
+			return 0, nonCanonical(start, "truncated varint")
+		}
+		b := s.data[s.off]
+		s.off++
+		if i == 9 && b > 1 {
+			return 0, nonCanonical(start, "varint overflows 64 bits")
+		}
+		v |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			if i > 0 && b == 0 {
+				return 0, nonCanonical(start, "non-minimal varint")
+			}
+			return v, nil
+		}
+	}
+}
+
+// bytes reads a length-delimited field, and returns the offset of its
+// payload.
+func (s *pbScanner) bytes() (int, int, error) {
+	start := s.off
+	n, err := s.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	if n > uint64(s.end-s.off) {
+		return 0, 0, nonCanonical(start, "length %d out of bounds", n)
+	}
+	off := s.off
+	s.off += int(n)
+	return off, s.off, nil
+}
+
+// checkCanonical checks that data is a dag-pb node in canonical form.
+func checkCanonical(data []byte) error {
+	s := &pbScanner{data: data, end: len(data)}
+	var prev string
+	links, hasData := 0, false
+	for s.off < s.end {
+		start := s.off
+		tag, err := s.varint()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tag == tagNodeLinks && !hasData:
+			off, end, err := s.bytes()
+			if err != nil {
+				return err
+			}
+			name, err := checkPBLink(data, off, end)
+			if err != nil {
+				return err
+			}
+			if links > 0 {
+				if name < prev {
+					return nonCanonical(start, "link %q is not sorted after %q", name, prev)
+				}
+				if name == prev && name != "" {
+					return nonCanonical(start, "duplicate link name %q", name)
+				}
+			}
+			prev = name
+			links++
+		case tag == tagNodeData && !hasData:
+			if _, _, err := s.bytes(); err != nil {
+				return err
+			}
+			hasData = true
+		case tag == tagNodeLinks || tag == tagNodeData:
+			return nonCanonical(start, "field %d after the data", tag>>3)
+		default:
+			return nonCanonical(start, "unexpected field %d of wire type %d", tag>>3, tag&7)
+		}
+	}
+	return nil
+}
+
+// checkPBLink checks the link in data[off:end], and returns its name.
+func checkPBLink(data []byte, off, end int) (string, error) {
+	s := &pbScanner{data: data, off: off, end: end}
+	var name string
+	last := uint64(0)
+	for s.off < s.end {
+		start := s.off
+		tag, err := s.varint()
+		if err != nil {
+			return "", err
+		}
+		if tag>>3 <= last {
+			return "", nonCanonical(start, "link field %d out of order", tag>>3)
+		}
+		if last == 0 && (tag == tagLinkName || tag == tagLinkTsize) {
+			return "", nonCanonical(off, "link without CID")
+		}
+		last = tag >> 3
+
+		switch tag {
+		case tagLinkHash:
+			hoff, hend, err := s.bytes()
+			if err != nil {
+				return "", err
+			}
+			if hoff == hend {
+				return "", nonCanonical(hoff, "undefined link CID")
+			}
+			n, _, err := cid.CidFromBytes(data[hoff:hend])
+			if err != nil {
+				return "", nonCanonical(hoff, "invalid link CID: %v", err)
+			}
+			if n != hend-hoff {
+				return "", nonCanonical(hoff+n, "trailing bytes after link CID")
+			}
+		case tagLinkName:
+			noff, nend, err := s.bytes()
+			if err != nil {
+				return "", err
+			}
+			name = string(data[noff:nend])
+		case tagLinkTsize:
+			if _, err := s.varint(); err != nil {
+				return "", err
+			}
+		default:
+			return "", nonCanonical(start, "unexpected link field %d of wire type %d", tag>>3, tag&7)
+		}
+	}
+	if last == 0 {
+		return "", nonCanonical(off, "link without CID")
+	}
+	return name, nil
+}
@@ -0,0 +1,114 @@
+package merkledag_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// pbField encodes a length-delimited protobuf field of a small tag.
+func pbField(tag byte, data []byte) []byte {
+	return append([]byte{tag, byte(len(data))}, data...)
+}
+
+// pbLink encodes a dag-pb link, without a name if name is nil.
+func pbLink(c cid.Cid, name []byte, extra ...byte) []byte {
+	var l []byte
+	if c.Defined() {
+		l = pbField(0x0a, c.Bytes())
+	}
+	if name != nil {
+		l = append(l, pbField(0x12, name)...)
+	}
+	return pbField(0x12, append(l, extra...))
+}
+
+func TestDecodeProtobufStrict(t *testing.T) {
+	child := NodeWithData([]byte("child")).Cid()
+	link := func(name string) []byte { return pbLink(child, []byte(name)) }
+	concat := func(parts ...[]byte) []byte {
+		var out []byte
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+		return out
+	}
+
+	// empty names, as in UnixFS files, may repeat
+	nd := NodeWithData([]byte("data"))
+	for _, name := range []string{"b", "a", "", ""} {
+		if err := nd.AddRawLink(name, &ipld.Link{Cid: child, Size: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	canonical, err := nd.EncodeProtobuf(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeProtobufStrict(canonical); err != nil {
+		t.Fatalf("expected canonical node to decode, got %v", err)
+	}
+
+	a, b := link("a"), link("b")
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		offset int
+	}{
+		{"unsorted", concat(b, a), len(b)},
+		{"duplicate", concat(a, a), len(a)},
+		{"missing CID", concat(a, pbLink(cid.Undef, []byte("b"))), len(a) + 2},
+		{"undefined CID", concat(pbField(0x12, pbField(0x0a, nil))), 4},
+		{"links after data", concat(pbField(0x0a, []byte("x")), a), 3},
+		{"unknown field", concat(a, []byte{0x20, 0x01}), len(a)},
+		{"unknown link field", concat(pbLink(child, []byte("a"), 0x20, 0x01)), len(a)},
+		{"non-minimal varint", concat(pbLink(child, []byte("a"), 0x18, 0x81, 0x00)), len(a) + 1},
+	} {
+		_, err := DecodeProtobufStrict(tc.data)
+		var nc ErrNonCanonical
+		if !errors.As(err, &nc) {
+			t.Fatalf("%s: expected a non-canonical error, got %v", tc.name, err)
+		}
+		if nc.Offset != tc.offset {
+			t.Fatalf("%s: expected offset %d, got %v", tc.name, tc.offset, err)
+		}
+	}
+	if _, err := DecodeProtobuf(concat(b, a)); err != nil {
+		t.Fatalf("expected lenient decoding of unsorted links, got %v", err)
+	}
+}
+
+func TestDAGServiceStrictDagPB(t *testing.T) {
+	ctx := context.Background()
+	bs := dstest.Bserv()
+
+	child := NodeWithData([]byte("child")).Cid()
+	data := append(pbLink(child, []byte("b")), pbLink(child, []byte("a"))...)
+	c, err := cid.V0Builder{}.Sum(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.AddBlock(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDAGService(bs).Get(ctx, c); err != nil {
+		t.Fatalf("expected lenient decoding, got %v", err)
+	}
+	_, err = newDAGService(t, bs, WithStrictDagPB()).Get(ctx, c)
+	var nc ErrNonCanonical
+	if !errors.As(err, &nc) {
+		t.Fatalf("expected a non-canonical error, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package traverse
+
+import (
+	"context"
+	"errors"
+	"iter"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// ErrDone is returned by Iterator.Next once every node was visited.
+var ErrDone = errors.New("traversal done")
+
+// ErrClosed is returned by Iterator.Next once the Iterator is closed.
+var ErrClosed = errors.New("iterator closed")
+
+// Iterator visits the nodes of a DAG one at a time, in the same order and
+// with the same options as Traverse, except for Func which is not used.
+//
+// Errors fetching a node are handed to ErrFunc, as with Traverse. The errors
+// it doesn't recover from are returned by Next, but don't end the traversal:
+// calling Next again continues with the next node. If the error came from
+// the context passed to Next, the node is fetched again on the next call.
+//
+// An Iterator must be closed once it is no longer used, such as after a page
+// of nodes, to cancel the nodes requested ahead of their visit with
+// Prefetch, which are otherwise fetched until the context they were
+// requested with is done. It is not safe for concurrent use, except for
+// Close.
+type Iterator struct {
+	t       traversal
+	root    State
+	started bool
+
+	// last is the last visited node, whose children are yet to be followed
+	// in DFSPre and BFS orders.
+	last *State
+
+	stack     []*frame // DFSPre and DFSPost
+	queue     queue    // BFS
+	expanding *frame   // BFS
+}
+
+// frame tracks the children of a node which were followed.
+type frame struct {
+	state State
+	links []*ipld.Link
+	i     int
+}
+
+// NewIterator returns an Iterator over the DAG of root. The Func of opts is
+// ignored.
+func NewIterator(root ipld.Node, opts Options) *Iterator {
+	closed, cancel := context.WithCancel(context.Background())
+	return &Iterator{
+		t: traversal{
+			opts:    opts,
+			seen:    map[string]struct{}{},
+			pending: map[cid.Cid]*prefetched{},
+			closed:  closed,
+			close:   cancel,
+		},
+		root: State{
+			Node:  root,
+			Depth: 0,
+		},
+	}
+}
+
+// Next returns the next node of the traversal, fetching nodes with ctx, or
+// ErrDone once the traversal is complete.
+func (it *Iterator) Next(ctx context.Context) (State, error) {
+	if it.t.closed.Err() != nil {
+		return State{}, ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return State{}, err
+	}
+	it.t.ctx = ctx
+	if !it.started {
+		it.t.reportRoot(it.root.Node)
+	}
+
+	switch it.t.opts.Order {
+	case DFSPost:
+		return it.nextDFSPost()
+	case BFS:
+		return it.nextBFS()
+	default:
+		return it.nextDFSPre()
+	}
+}
+
+// Close cancels the prefetches in flight. Next returns ErrClosed afterwards.
+func (it *Iterator) Close() {
+	it.t.close()
+}
+
+// SkipSubtree skips the children of the node last returned by Next. It has
+// no effect in DFSPost order, where children are visited first.
+func (it *Iterator) SkipSubtree() {
+	it.last = nil
+}
+
+// All returns an iterator over the remaining nodes of the traversal, and the
+// errors returned by Next. It stops after an error from ctx.
+func (it *Iterator) All(ctx context.Context) iter.Seq2[State, error] {
+	return func(yield func(State, error) bool) {
+		for {
+			state, err := it.Next(ctx)
+			if err == ErrDone {
+				return
+			}
+			if !yield(state, err) {
+				return
+			}
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// nextChild fetches the next child of f. It returns false once every child
+// was followed, and a nil node for skipped children.
+func (it *Iterator) nextChild(f *frame) (ipld.Node, bool, error) {
+	if f.i >= len(f.links) {
+		return nil, false, nil
+	}
+	it.t.prefetch(f.links, f.i)
+	node, err := it.t.getNode(f.links[f.i], f.state.Depth+1)
+	if err != nil && it.t.ctx.Err() != nil {
+		// retry on the next call
+		return nil, true, err
+	}
+	f.i++
+	return node, true, err
+}
+
+func (it *Iterator) nextDFSPre() (State, error) {
+	if !it.started {
+		it.started = true
+		it.last = &it.root
+		return it.root, nil
+	}
+
+	if it.last != nil {
+		it.stack = append(it.stack, &frame{state: *it.last, links: it.t.childLinks(*it.last)})
+		it.last = nil
+	}
+	for len(it.stack) > 0 {
+		f := it.stack[len(it.stack)-1]
+		node, more, err := it.nextChild(f)
+		if err != nil {
+			return State{}, err
+		}
+		if !more {
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+		if node == nil { // skip
+			continue
+		}
+
+		next := State{
+			Node:  node,
+			Depth: f.state.Depth + 1,
+		}
+		it.last = &next
+		return next, nil
+	}
+	return State{}, ErrDone
+}
+
+func (it *Iterator) nextDFSPost() (State, error) {
+	if !it.started {
+		it.started = true
+		it.stack = []*frame{{state: it.root, links: it.t.childLinks(it.root)}}
+	}
+
+	for len(it.stack) > 0 {
+		f := it.stack[len(it.stack)-1]
+		node, more, err := it.nextChild(f)
+		if err != nil {
+			return State{}, err
+		}
+		if !more {
+			it.stack = it.stack[:len(it.stack)-1]
+			return f.state, nil
+		}
+		if node == nil { // skip
+			continue
+		}
+
+		next := State{
+			Node:  node,
+			Depth: f.state.Depth + 1,
+		}
+		it.stack = append(it.stack, &frame{state: next, links: it.t.childLinks(next)})
+	}
+	return State{}, ErrDone
+}
+
+func (it *Iterator) nextBFS() (State, error) {
+	if !it.started {
+		it.started = true
+		if _, err := it.t.shouldSkip(it.root.Node); err != nil {
+			return State{}, err
+		}
+		it.queue.enq(it.root)
+	}
+
+	if it.last != nil {
+		it.expanding = &frame{state: *it.last, links: it.t.childLinks(*it.last)}
+		it.last = nil
+	}
+	if f := it.expanding; f != nil {
+		for {
+			node, more, err := it.nextChild(f)
+			if err != nil {
+				return State{}, err
+			}
+			if !more {
+				break
+			}
+			if node == nil { // skip
+				continue
+			}
+			it.queue.enq(State{
+				Node:  node,
+				Depth: f.state.Depth + 1,
+			})
+		}
+		it.expanding = nil
+	}
+
+	if it.queue.len() == 0 {
+		return State{}, ErrDone
+	}
+	next := it.queue.deq()
+	it.last = &next
+	return next, nil
+}
@@ -0,0 +1,172 @@
+package traverse
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	mdag "github.com/ipfs/go-merkledag"
+	mdagtest "github.com/ipfs/go-merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func stateData(s State) string {
+	return string(s.Node.(*mdag.ProtoNode).Data())
+}
+
+func TestIterator(t *testing.T) {
+	ctx := context.Background()
+	ds := mdagtest.Mock()
+	root := newBinaryDAG(t, ds)
+
+	for _, order := range []Order{DFSPre, DFSPost, BFS} {
+		for _, skipDups := range []bool{false, true} {
+			opts := Options{DAG: ds, Order: order, SkipDuplicates: skipDups}
+			expect := collectData(t, root, opts, "")
+
+			// stop after a few nodes, then resume
+			it := NewIterator(root, opts)
+			var got []string
+			for s, err := range it.All(ctx) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, stateData(s))
+				if len(got) == 3 {
+					break
+				}
+			}
+			for s, err := range it.All(ctx) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, stateData(s))
+			}
+			if !slices.Equal(got, expect) {
+				t.Fatalf("order %d: expected %v, got %v", order, expect, got)
+			}
+			if _, err := it.Next(ctx); err != ErrDone {
+				t.Fatalf("expected ErrDone, got %v", err)
+			}
+		}
+	}
+}
+
+func TestIteratorErrors(t *testing.T) {
+	ctx := context.Background()
+	ds := mdagtest.Mock()
+	root := newBinaryTree(t, ds)
+
+	// remove /a/aa/aaa
+	aa, err := root.Links()[0].GetNode(ctx, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Remove(ctx, aa.Links()[0].Cid); err != nil {
+		t.Fatal(err)
+	}
+
+	it := NewIterator(root, Options{DAG: ds})
+	var got []string
+	var errs int
+	for s, err := range it.All(ctx) {
+		if err != nil {
+			if !ipld.IsNotFound(err) {
+				t.Fatal(err)
+			}
+			errs++
+			continue
+		}
+		got = append(got, stateData(s))
+	}
+	expect := []string{"/a", "/a/aa", "/a/aa/aab", "/a/ab", "/a/ab/aba", "/a/ab/abb"}
+	if errs != 1 || !slices.Equal(got, expect) {
+		t.Fatalf("expected %v and 1 error, got %v and %d errors", expect, got, errs)
+	}
+
+	// a canceled context doesn't lose nodes
+	it = NewIterator(root, Options{DAG: ds, Order: BFS})
+	if _, err := it.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := it.Next(canceled); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	s, err := it.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stateData(s) != "/a/aa" {
+		t.Fatalf("expected /a/aa, got %s", stateData(s))
+	}
+}
+
+func TestIteratorSkipSubtree(t *testing.T) {
+	ctx := context.Background()
+	ds := mdagtest.Mock()
+	root := newBinaryTree(t, ds)
+
+	it := NewIterator(root, Options{DAG: ds})
+	var got []string
+	for s, err := range it.All(ctx) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, stateData(s))
+		if stateData(s) == "/a/ab" {
+			it.SkipSubtree()
+		}
+	}
+	expect := []string{"/a", "/a/aa", "/a/aa/aaa", "/a/aa/aab", "/a/ab"}
+	if !slices.Equal(got, expect) {
+		t.Fatalf("expected %v, got %v", expect, got)
+	}
+}
+
+// stallingGetter serves the first node of every GetMany call, and holds the
+// call until its context is done.
+type stallingGetter struct {
+	ipld.NodeGetter
+	released chan struct{}
+}
+
+func (g *stallingGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption)
+	go func() {
+		defer close(out)
+		nd, err := g.NodeGetter.Get(ctx, keys[0])
+		out <- &ipld.NodeOption{Node: nd, Err: err}
+		<-ctx.Done()
+		close(g.released)
+	}()
+	return out
+}
+
+func TestIteratorClose(t *testing.T) {
+	ctx := context.Background()
+	ds := mdagtest.Mock()
+	root := newFan(t, ds)
+
+	dag := &stallingGetter{NodeGetter: ds, released: make(chan struct{})}
+	it := NewIterator(root, Options{DAG: dag, Prefetch: 2})
+	for i := 0; i < 2; i++ {
+		if _, err := it.Next(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it.Close()
+	select {
+	case <-dag.released:
+	case <-time.After(2 * time.Second):
+		t.Fatal("prefetch still in flight after Close")
+	}
+	if _, err := it.Next(ctx); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"context"
 	"errors"
 
+	cid "github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
+	mdag "github.com/ipfs/go-merkledag"
 )
 
 // Order is an identifier for traversal algorithm orders
@@ -29,6 +31,31 @@ type Options struct {
 	ErrFunc ErrFunc         // see ErrFunc. Optional
 
 	SkipDuplicates bool // whether to skip duplicate nodes
+
+	// VisitedSet, if set, replaces the in-memory set of nodes used by
+	// SkipDuplicates, such as with a mdag.NewDatastoreVisitedSet for DAGs
+	// too large to track in memory. Nodes are recorded at depth 0.
+	VisitedSet mdag.VisitedSet
+
+	// LinkFilter, if set, is called with every link before following it.
+	// Links for which it returns false are neither fetched nor visited.
+	LinkFilter func(parent ipld.Node, l *ipld.Link) bool
+
+	// MaxDepth, if positive, is the maximum depth of the visited nodes. The
+	// root is at depth 0.
+	MaxDepth int
+
+	// Prefetch is the number of child nodes requested at once with GetMany,
+	// one batch ahead of their visit, instead of fetching them one at a
+	// time. Zero disables prefetching. Nodes are visited in the same order
+	// either way.
+	Prefetch int
+
+	// OnProgress, if set, is called with the progress of the traversal
+	// every time a node is fetched. The root counts as the first fetched
+	// node, and InFlight is the number of prefetched nodes yet to be
+	// visited.
+	OnProgress func(mdag.ProgressEvent)
 }
 
 // State is a current traversal state
@@ -38,11 +65,33 @@ type State struct {
 }
 
 type traversal struct {
+	ctx  context.Context
 	opts Options
 	seen map[string]struct{}
+
+	// closed is canceled once the Iterator is closed, to cancel the
+	// prefetches in flight
+	closed context.Context
+	close  context.CancelFunc
+
+	// pending holds the nodes requested ahead of their visit
+	pending map[cid.Cid]*prefetched
+
+	progress mdag.ProgressEvent
+}
+
+// prefetched is a node requested ahead of its visit. node is nil if it could
+// not be fetched, and is only set once done is closed.
+type prefetched struct {
+	done chan struct{}
+	node ipld.Node
 }
 
 func (t *traversal) shouldSkip(n ipld.Node) (bool, error) {
+	if t.opts.SkipDuplicates && t.opts.VisitedSet != nil {
+		ok, err := t.opts.VisitedSet.Visit(t.ctx, n.Cid(), 0)
+		return !ok, err
+	}
 	if t.opts.SkipDuplicates {
 		k := n.Cid()
 		if _, found := t.seen[k.KeyString()]; found {
@@ -54,21 +103,49 @@ func (t *traversal) shouldSkip(n ipld.Node) (bool, error) {
 	return false, nil
 }
 
-func (t *traversal) callFunc(next State) error {
-	return t.opts.Func(next)
+// reportProgress records a node fetched at depth, or a skipped error if node
+// is nil.
+func (t *traversal) reportProgress(node ipld.Node, depth int) {
+	if t.opts.OnProgress == nil {
+		return
+	}
+	if node != nil {
+		t.progress.Nodes++
+		t.progress.Bytes += uint64(len(node.RawData()))
+	} else {
+		t.progress.Skipped++
+	}
+	t.progress.Depth = depth
+	t.progress.InFlight = len(t.pending)
+	t.opts.OnProgress(t.progress)
 }
 
-// getNode returns the node for link. If it return an error,
+// reportRoot records the root as fetched, and estimates the size of the DAG
+// from it.
+func (t *traversal) reportRoot(root ipld.Node) {
+	if t.opts.OnProgress == nil {
+		return
+	}
+	t.progress.TotalBytes, _ = root.Size()
+	t.reportProgress(root, 0)
+}
+
+// getNode returns the node for link, at depth. If it return an error,
 // stop processing. if it returns a nil node, just skip it.
 //
-// the error handling is a little complicated.
-func (t *traversal) getNode(link *ipld.Link) (ipld.Node, error) {
+// the error handling is a little complicated. Errors caused by the
+// cancellation of the traversal context are not passed to ErrFunc.
+func (t *traversal) getNode(link *ipld.Link, depth int) (ipld.Node, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	getNode := func(l *ipld.Link) (ipld.Node, error) {
-		next, err := l.GetNode(context.TODO(), t.opts.DAG)
+		next, err := t.fetch(l)
 		if err != nil {
 			return nil, err
 		}
+		t.reportProgress(next, depth)
 
 		skip, err := t.shouldSkip(next)
 		if skip {
@@ -78,16 +155,112 @@ func (t *traversal) getNode(link *ipld.Link) (ipld.Node, error) {
 	}
 
 	next, err := getNode(link)
+	if err != nil && t.ctx.Err() != nil {
+		return nil, t.ctx.Err()
+	}
 	if err != nil && t.opts.ErrFunc != nil { // attempt recovery.
 		err = t.opts.ErrFunc(err)
 		next = nil // skip regardless
+		if err == nil {
+			t.reportProgress(nil, depth)
+		}
 	}
 	return next, err
 }
 
+// childLinks returns the links to follow from curr, according to MaxDepth
+// and LinkFilter.
+func (t *traversal) childLinks(curr State) []*ipld.Link {
+	if t.opts.MaxDepth > 0 && curr.Depth >= t.opts.MaxDepth {
+		return nil
+	}
+	links := curr.Node.Links()
+	if t.opts.LinkFilter == nil {
+		return links
+	}
+	filtered := make([]*ipld.Link, 0, len(links))
+	for _, l := range links {
+		if t.opts.LinkFilter(curr.Node, l) {
+			filtered = append(filtered, l)
+		}
+	}
+	return filtered
+}
+
+// fetch returns the node for link, waiting for it if it was prefetched. Nodes
+// which failed to be prefetched are fetched again, to report the error.
+func (t *traversal) fetch(link *ipld.Link) (ipld.Node, error) {
+	if p, ok := t.pending[link.Cid]; ok {
+		delete(t.pending, link.Cid)
+		select {
+		case <-p.done:
+		case <-t.ctx.Done():
+			return nil, t.ctx.Err()
+		}
+		if p.node != nil {
+			return p.node, nil
+		}
+	}
+	return link.GetNode(t.ctx, t.opts.DAG)
+}
+
+// prefetch requests the nodes of links when reaching the start of a batch,
+// up to the end of the next batch.
+func (t *traversal) prefetch(links []*ipld.Link, i int) {
+	n := t.opts.Prefetch
+	if n <= 0 || i%n != 0 {
+		return
+	}
+	links = links[i:min(i+2*n, len(links))]
+
+	var keys []cid.Cid
+	requested := make(map[cid.Cid]*prefetched)
+	for _, l := range links {
+		if _, ok := t.pending[l.Cid]; ok {
+			continue
+		}
+		if _, ok := t.seen[l.Cid.KeyString()]; ok && t.opts.SkipDuplicates {
+			continue
+		}
+		p := &prefetched{done: make(chan struct{})}
+		t.pending[l.Cid] = p
+		requested[l.Cid] = p
+		keys = append(keys, l.Cid)
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(t.ctx)
+	stop := context.AfterFunc(t.closed, cancel)
+	out := t.opts.DAG.GetMany(ctx, keys)
+	go func() {
+		defer cancel()
+		defer stop()
+		for opt := range out {
+			if opt.Err != nil {
+				continue
+			}
+			if p, ok := requested[opt.Node.Cid()]; ok {
+				p.node = opt.Node
+				close(p.done)
+				delete(requested, opt.Node.Cid())
+			}
+		}
+		for _, p := range requested {
+			close(p.done)
+		}
+	}()
+}
+
+// ErrSkipSubtree can be returned by a Func to skip the children of the
+// current node, and continue the traversal. It has no effect in DFSPost
+// order, where children are visited first.
+var ErrSkipSubtree = errors.New("skip subtree")
+
 // Func is the type of the function called for each dag.Node visited by Traverse.
 // The traversal argument contains the current traversal state.
-// If an error is returned, processing stops.
+// If an error is returned, processing stops, unless it is ErrSkipSubtree.
 type Func func(current State) error
 
 // ErrFunc is provided to handle problems when walking to the Node. Traverse
@@ -104,100 +277,35 @@ type ErrFunc func(err error) error
 // Traverse initiates a DAG traversal with the given options starting at
 // the given root.
 func Traverse(root ipld.Node, o Options) error {
-	t := traversal{
-		opts: o,
-		seen: map[string]struct{}{},
-	}
-
-	state := State{
-		Node:  root,
-		Depth: 0,
-	}
-
-	switch o.Order {
-	default:
-		return dfsPreTraverse(state, &t)
-	case DFSPre:
-		return dfsPreTraverse(state, &t)
-	case DFSPost:
-		return dfsPostTraverse(state, &t)
-	case BFS:
-		return bfsTraverse(state, &t)
-	}
-}
-
-type dfsFunc func(state State, t *traversal) error
-
-func dfsPreTraverse(state State, t *traversal) error {
-	if err := t.callFunc(state); err != nil {
-		return err
-	}
-	return dfsDescend(dfsPreTraverse, state, t)
-}
-
-func dfsPostTraverse(state State, t *traversal) error {
-	if err := dfsDescend(dfsPostTraverse, state, t); err != nil {
-		return err
-	}
-	return t.callFunc(state)
+	return TraverseContext(context.Background(), root, o)
 }
 
-func dfsDescend(df dfsFunc, curr State, t *traversal) error {
-	for _, l := range curr.Node.Links() {
-		node, err := t.getNode(l)
-		if err != nil {
-			return err
+// TraverseContext is like Traverse, but fetches nodes with the given context.
+// Once the context is done, the traversal stops and returns ctx.Err(), which
+// is checked before visiting and before fetching any node.
+func TraverseContext(ctx context.Context, root ipld.Node, o Options) error {
+	it := NewIterator(root, o)
+	defer it.Close()
+	for {
+		state, err := it.Next(ctx)
+		if err == ErrDone {
+			return nil
 		}
-		if node == nil { // skip
-			continue
-		}
-
-		next := State{
-			Node:  node,
-			Depth: curr.Depth + 1,
-		}
-		if err := df(next, t); err != nil {
+		if err != nil {
 			return err
 		}
-	}
-	return nil
-}
-
-func bfsTraverse(root State, t *traversal) error {
-
-	if skip, err := t.shouldSkip(root.Node); skip || err != nil {
-		return err
-	}
-
-	var q queue
-	q.enq(root)
-	for q.len() > 0 {
-		curr := q.deq()
-		if curr.Node == nil {
-			return errors.New("failed to dequeue though queue not empty")
-		}
 
-		// call user's func
-		if err := t.callFunc(curr); err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-
-		for _, l := range curr.Node.Links() {
-			node, err := t.getNode(l)
-			if err != nil {
-				return err
-			}
-			if node == nil { // skip
+		if err := o.Func(state); err != nil {
+			if errors.Is(err, ErrSkipSubtree) {
+				it.SkipSubtree()
 				continue
 			}
-
-			q.enq(State{
-				Node:  node,
-				Depth: curr.Depth + 1,
-			})
+			return err
 		}
 	}
-	return nil
 }
 
 type queue struct {
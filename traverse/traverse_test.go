@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	mdag "github.com/ipfs/go-merkledag"
 	mdagtest "github.com/ipfs/go-merkledag/test"
 
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
 	ipld "github.com/ipfs/go-ipld-format"
 )
 
@@ -324,7 +330,198 @@ func TestBFSSkip(t *testing.T) {
 `))
 }
 
+// blockingGetter blocks every fetch until its context is done.
+type blockingGetter struct{}
+
+func (blockingGetter) Get(ctx context.Context, _ cid.Cid) (ipld.Node, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingGetter) GetMany(ctx context.Context, _ []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, 1)
+	out <- &ipld.NodeOption{Err: ctx.Err()}
+	close(out)
+	return out
+}
+
+func TestTraverseContext(t *testing.T) {
+	ds := mdagtest.Mock()
+	root := newBinaryDAG(t, ds)
+
+	for _, order := range []Order{DFSPre, DFSPost, BFS} {
+		ctx, cancel := context.WithCancel(context.Background())
+		visits := 0
+		opts := Options{DAG: ds, Order: order, Func: func(State) error {
+			visits++
+			if visits == 3 {
+				cancel()
+			}
+			return nil
+		}}
+		if err := TraverseContext(ctx, root, opts); err != context.Canceled {
+			t.Fatalf("order %d: expected context.Canceled, got %v", order, err)
+		}
+		if visits != 3 {
+			t.Fatalf("order %d: expected 3 visits, got %d", order, visits)
+		}
+	}
+
+	// cancellation is not handed to ErrFunc
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	opts := Options{
+		DAG:     blockingGetter{},
+		Func:    func(State) error { return nil },
+		ErrFunc: func(error) error { return nil },
+	}
+	if err := TraverseContext(ctx, root, opts); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func collectData(t *testing.T, root ipld.Node, opts Options, skip string) []string {
+	var out []string
+	opts.Func = func(current State) error {
+		data := string(current.Node.(*mdag.ProtoNode).Data())
+		out = append(out, data)
+		if data == skip {
+			return ErrSkipSubtree
+		}
+		return nil
+	}
+	if err := Traverse(root, opts); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestTraversePruning(t *testing.T) {
+	ds := mdagtest.Mock()
+	root := newBinaryTree(t, ds)
+
+	dag := &countingGetter{NodeGetter: ds}
+	opts := Options{DAG: dag, LinkFilter: func(parent ipld.Node, l *ipld.Link) bool {
+		return !strings.HasSuffix(l.Name, "/ab")
+	}}
+	got := collectData(t, root, opts, "")
+	if expect := []string{"/a", "/a/aa", "/a/aa/aaa", "/a/aa/aab"}; !slices.Equal(got, expect) {
+		t.Fatalf("filtering links: expected %v, got %v", expect, got)
+	}
+	if dag.gets != 3 {
+		t.Fatalf("filtered links were fetched: %d fetches", dag.gets)
+	}
+
+	for order, expect := range map[Order][]string{
+		DFSPre:  {"/a", "/a/aa", "/a/ab", "/a/ab/aba", "/a/ab/abb"},
+		BFS:     {"/a", "/a/aa", "/a/ab", "/a/ab/aba", "/a/ab/abb"},
+		DFSPost: {"/a/aa/aaa", "/a/aa/aab", "/a/aa", "/a/ab/aba", "/a/ab/abb", "/a/ab", "/a"},
+	} {
+		got := collectData(t, root, Options{DAG: ds, Order: order}, "/a/aa")
+		if !slices.Equal(got, expect) {
+			t.Fatalf("order %d: skipping subtree: expected %v, got %v", order, expect, got)
+		}
+	}
+
+	got = collectData(t, root, Options{DAG: ds, MaxDepth: 1}, "")
+	if expect := []string{"/a", "/a/aa", "/a/ab"}; !slices.Equal(got, expect) {
+		t.Fatalf("max depth: expected %v, got %v", expect, got)
+	}
+}
+
+// countingGetter counts the Get and GetMany calls made to a NodeGetter.
+type countingGetter struct {
+	ipld.NodeGetter
+	gets, getManys int
+}
+
+func (g *countingGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	g.gets++
+	return g.NodeGetter.Get(ctx, c)
+}
+
+func (g *countingGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *ipld.NodeOption {
+	g.getManys++
+	return g.NodeGetter.GetMany(ctx, keys)
+}
+
+func TestTraversePrefetch(t *testing.T) {
+	ds := mdagtest.Mock()
+	root := newFan(t, ds)
+
+	dag := &countingGetter{NodeGetter: ds}
+	opts := Options{DAG: dag, Prefetch: 2, Func: func(State) error { return nil }}
+	if err := Traverse(root, opts); err != nil {
+		t.Fatal(err)
+	}
+	if dag.gets != 0 || dag.getManys != 1 {
+		t.Fatalf("expected a single GetMany call, got %d Get and %d GetMany", dag.gets, dag.getManys)
+	}
+
+	// nodes which can't be prefetched are still reported to ErrFunc
+	missing := root.Links()[1].Cid
+	if err := ds.Remove(context.Background(), missing); err != nil {
+		t.Fatal(err)
+	}
+	var errs int
+	opts.ErrFunc = func(err error) error {
+		errs++
+		return nil
+	}
+	if err := Traverse(root, opts); err != nil {
+		t.Fatal(err)
+	}
+	if errs != 1 {
+		t.Fatalf("expected 1 error, got %d", errs)
+	}
+}
+
+func TestTraverseVisitedSet(t *testing.T) {
+	ds := mdagtest.Mock()
+	root := newBinaryDAG(t, ds)
+
+	for _, order := range []Order{DFSPre, DFSPost, BFS} {
+		opts := Options{DAG: ds, Order: order, SkipDuplicates: true}
+		expect := collectData(t, root, opts, "")
+
+		opts.VisitedSet = mdag.NewDatastoreVisitedSet(dssync.MutexWrap(datastore.NewMapDatastore()))
+		if got := collectData(t, root, opts, ""); !slices.Equal(got, expect) {
+			t.Fatalf("order %d: expected %v, got %v", order, expect, got)
+		}
+	}
+}
+
+func TestTraverseProgress(t *testing.T) {
+	ds := mdagtest.Mock()
+	root := newBinaryTree(t, ds)
+	size, err := root.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []mdag.ProgressEvent
+	opts := Options{DAG: ds, Prefetch: 2, OnProgress: func(ev mdag.ProgressEvent) {
+		events = append(events, ev)
+	}}
+	collectData(t, root, opts, "")
+	if len(events) != 7 {
+		t.Fatalf("expected 7 events, got %d", len(events))
+	}
+	last := events[len(events)-1]
+	if last.Nodes != 7 || last.Bytes != size || last.TotalBytes != size || last.Depth != 2 || last.InFlight != 0 {
+		t.Fatalf("unexpected last event: %+v", last)
+	}
+}
+
 func testWalkOutputs(t *testing.T, root ipld.Node, opts Options, expect []byte) {
+	testWalkOutputsOnce(t, root, opts, expect)
+
+	// prefetching doesn't change the order
+	opts.Prefetch = 3
+	testWalkOutputsOnce(t, root, opts, expect)
+}
+
+func testWalkOutputsOnce(t *testing.T, root ipld.Node, opts Options, expect []byte) {
 	expect = bytes.TrimLeft(expect, "\n")
 
 	buf := new(bytes.Buffer)
@@ -0,0 +1,120 @@
+package merkledag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// ErrHashMismatch is returned when the data of a block read from the
+// blockservice doesn't match the requested CID. It matches
+// blockstore.ErrHashMismatch with errors.Is.
+type ErrHashMismatch struct {
+	// Expected is the requested CID.
+	Expected cid.Cid
+	// Actual is the CID of the data, with the same prefix.
+	Actual cid.Cid
+}
+
+func (e ErrHashMismatch) Error() string {
+	return fmt.Sprintf("hash mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// Is makes ErrHashMismatch match blockstore.ErrHashMismatch.
+func (e ErrHashMismatch) Is(target error) bool {
+	return target == blockstore.ErrHashMismatch
+}
+
+// IsHashMismatch returns whether err is or wraps an ErrHashMismatch.
+func IsHashMismatch(err error) bool {
+	var mismatch ErrHashMismatch
+	return errors.As(err, &mismatch)
+}
+
+// VerifyPolicy reports whether the data of the block read for c must be
+// hashed, and checked against c before decoding it.
+type VerifyPolicy func(c cid.Cid) bool
+
+// VerifyAll is a VerifyPolicy verifying every block.
+func VerifyAll(cid.Cid) bool {
+	return true
+}
+
+// VerifySample returns a VerifyPolicy verifying a random fraction of the
+// blocks, between 0 and 1, to bound the cost of verification on busy
+// services.
+func VerifySample(fraction float64) VerifyPolicy {
+	return func(cid.Cid) bool {
+		return rand.Float64() < fraction
+	}
+}
+
+// verifyData checks that data matches c, according to policy.
+func verifyData(policy VerifyPolicy, c cid.Cid, data []byte) error {
+	if policy == nil || !policy(c) {
+		return nil
+	}
+	actual, err := c.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !actual.Equals(c) {
+		return ErrHashMismatch{Expected: c, Actual: actual}
+	}
+	return nil
+}
+
+// NewVerifiedSession is like NewSession, but verifies the blocks read
+// through the session according to policy, whatever the policy of g. Blocks
+// of a DAGService created by NewDAGService are verified before being
+// decoded, and never answered from its node cache. For other NodeGetters,
+// the raw data of the decoded nodes is verified.
+func NewVerifiedSession(ctx context.Context, g format.NodeGetter, policy VerifyPolicy) format.NodeGetter {
+	if n, ok := g.(*dagService); ok {
+		return n.session(ctx, policy)
+	}
+	return &verifyingGetter{ng: NewSession(ctx, g), verify: policy}
+}
+
+// verifyingGetter verifies the nodes returned by a NodeGetter.
+type verifyingGetter struct {
+	ng     format.NodeGetter
+	verify VerifyPolicy
+}
+
+func (vg *verifyingGetter) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	nd, err := vg.ng.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyData(vg.verify, c, nd.RawData()); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+func (vg *verifyingGetter) GetMany(ctx context.Context, keys []cid.Cid) <-chan *format.NodeOption {
+	in := vg.ng.GetMany(ctx, keys)
+	out := make(chan *format.NodeOption, len(keys))
+	go func() {
+		defer close(out)
+		for opt := range in {
+			if opt.Err == nil {
+				if err := verifyData(vg.verify, opt.Node.Cid(), opt.Node.RawData()); err != nil {
+					opt = &format.NodeOption{Err: err}
+				}
+			}
+			select {
+			case out <- opt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,86 @@
+package merkledag_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// addCorruptBlock stores the data "bar" under the CID of "foo".
+func addCorruptBlock(t *testing.T, bs bserv.BlockService) (expected, actual cid.Cid) {
+	builder := cid.V1Builder{Codec: cid.Raw, MhType: mh.SHA2_256}
+	expected, err := builder.Sum([]byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err = builder.Sum([]byte("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid([]byte("bar"), expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.AddBlock(context.Background(), blk); err != nil {
+		t.Fatal(err)
+	}
+	return expected, actual
+}
+
+// plainGetter hides the concrete type of a NodeGetter.
+type plainGetter struct {
+	ipld.NodeGetter
+}
+
+func TestVerifyPolicies(t *testing.T) {
+	ctx := context.Background()
+	bs := dstest.Bserv()
+	expected, actual := addCorruptBlock(t, bs)
+
+	checkMismatch := func(name string, err error) {
+		t.Helper()
+		var mismatch ErrHashMismatch
+		if !errors.As(err, &mismatch) || !IsHashMismatch(err) {
+			t.Fatalf("%s: expected a hash mismatch, got %v", name, err)
+		}
+		if mismatch.Expected != expected || mismatch.Actual != actual {
+			t.Fatalf("%s: expected %s to hash to %s, got %+v", name, expected, actual, mismatch)
+		}
+	}
+
+	checkMismatch("service", func() error {
+		_, err := newDAGService(t, bs, WithVerify(VerifyAll)).Get(ctx, expected)
+		return err
+	}())
+	if _, err := newDAGService(t, bs, WithVerify(VerifySample(0))).Get(ctx, expected); err != nil {
+		t.Fatalf("expected no verification, got %v", err)
+	}
+
+	// sessions verify reads whatever the policy of their service
+	dserv := newDAGService(t, bs, WithNodeCache(1<<20))
+	if _, err := dserv.Get(ctx, expected); err != nil {
+		t.Fatal(err)
+	}
+	for name, ng := range map[string]ipld.NodeGetter{
+		"session":         NewVerifiedSession(ctx, dserv, VerifyAll),
+		"wrapped session": NewVerifiedSession(ctx, plainGetter{dserv}, VerifyAll),
+	} {
+		_, err := ng.Get(ctx, expected)
+		checkMismatch(name, err)
+		for opt := range ng.GetMany(ctx, []cid.Cid{expected}) {
+			checkMismatch(name+" GetMany", opt.Err)
+		}
+	}
+	if _, err := NewVerifiedSession(ctx, newDAGService(t, bs, WithVerify(VerifyAll)), nil).Get(ctx, expected); err != nil {
+		t.Fatalf("expected no verification, got %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package merkledag
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	bbloom "github.com/ipfs/bbloom"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+// VisitedSet records the nodes visited by a walk, with the lowest depth they
+// were visited at.
+//
+// Walks which don't need depths, such as FetchGraph without a depth limit,
+// visit every node at depth 0.
+type VisitedSet interface {
+	// Visit records a visit of c at depth. It returns true if c was not
+	// visited before, or only at a greater depth, in which case its links
+	// must be walked again.
+	Visit(ctx context.Context, c cid.Cid, depth int) (bool, error)
+}
+
+// memoryVisitedSet is a VisitedSet kept in a map.
+type memoryVisitedSet struct {
+	lk  sync.Mutex
+	set map[cid.Cid]int
+	// changed holds the nodes visited since the last checkpoint, once
+	// trackChanges was called.
+	changed map[cid.Cid]struct{}
+}
+
+// NewMemoryVisitedSet returns a VisitedSet kept in memory. It is the default
+// set of FetchGraph.
+func NewMemoryVisitedSet() VisitedSet {
+	return &memoryVisitedSet{set: make(map[cid.Cid]int)}
+}
+
+func (s *memoryVisitedSet) Visit(_ context.Context, c cid.Cid, depth int) (bool, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if oldDepth, ok := s.set[c]; ok && oldDepth <= depth {
+		return false, nil
+	}
+	s.set[c] = depth
+	if s.changed != nil {
+		s.changed[c] = struct{}{}
+	}
+	return true, nil
+}
+
+// trackChanges makes the set record the nodes visited from now on, for
+// checkpoints.
+func (s *memoryVisitedSet) trackChanges() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.changed = make(map[cid.Cid]struct{})
+}
+
+// changes returns the nodes visited since the last call, or the call to
+// trackChanges.
+func (s *memoryVisitedSet) changes() []CidDepth {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	out := make([]CidDepth, 0, len(s.changed))
+	for c := range s.changed {
+		out = append(out, CidDepth{Cid: c, Depth: s.set[c]})
+	}
+	clear(s.changed)
+	return out
+}
+
+// datastoreVisitedSet is a VisitedSet kept in a datastore.
+type datastoreVisitedSet struct {
+	lk    sync.Mutex
+	store ds.Datastore
+}
+
+// NewDatastoreVisitedSet returns a VisitedSet kept in d, for walks whose
+// visited nodes don't fit in memory. Entries are never deleted: d should be
+// dedicated to a single walk, and discarded after it. A walk interrupted by
+// a checkpoint is resumed with a set kept in the same datastore.
+func NewDatastoreVisitedSet(d ds.Datastore) VisitedSet {
+	return &datastoreVisitedSet{store: d}
+}
+
+func (s *datastoreVisitedSet) Visit(ctx context.Context, c cid.Cid, depth int) (bool, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	key := dshelp.NewKeyFromBinary(c.Bytes())
+	val, err := s.store.Get(ctx, key)
+	switch err {
+	case nil:
+		oldDepth, n := binary.Uvarint(val)
+		if n <= 0 {
+			return false, errors.New("invalid visited set entry")
+		}
+		if int(oldDepth) <= depth {
+			return false, nil
+		}
+	case ds.ErrNotFound:
+	default:
+		return false, err
+	}
+	return true, s.put(ctx, key, depth)
+}
+
+// add records c without looking it up first, for nodes known to be new.
+func (s *datastoreVisitedSet) add(ctx context.Context, c cid.Cid, depth int) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return s.put(ctx, dshelp.NewKeyFromBinary(c.Bytes()), depth)
+}
+
+func (s *datastoreVisitedSet) put(ctx context.Context, key ds.Key, depth int) error {
+	return s.store.Put(ctx, key, binary.AppendUvarint(nil, uint64(depth)))
+}
+
+// bloomVisitedSet is a VisitedSet answering from a bloom filter when it can.
+type bloomVisitedSet struct {
+	lk      sync.Mutex
+	bloom   *bbloom.Bloom
+	confirm *datastoreVisitedSet
+}
+
+// NewBloomVisitedSet returns a VisitedSet backed by a bloom filter sized for
+// expected entries with the given false positive rate.
+//
+// If confirm is not nil, every node is also recorded in it, but it is only
+// read when the filter reports a node as possibly visited: the set is exact,
+// and new nodes cost a single datastore write. Otherwise the set only uses
+// the filter's memory, but false positives make the walk skip nodes which
+// were not visited, and depths are ignored. Such a set must only be used
+// when missing a few nodes is acceptable, and without depth limits.
+func NewBloomVisitedSet(expected int, falsePositiveRate float64, confirm ds.Datastore) (VisitedSet, error) {
+	bloom, err := bbloom.New(float64(expected), falsePositiveRate)
+	if err != nil {
+		return nil, err
+	}
+	s := &bloomVisitedSet{bloom: bloom}
+	if confirm != nil {
+		s.confirm = &datastoreVisitedSet{store: confirm}
+	}
+	return s, nil
+}
+
+func (s *bloomVisitedSet) Visit(ctx context.Context, c cid.Cid, depth int) (bool, error) {
+	s.lk.Lock()
+	added := s.bloom.AddIfNotHas(c.Hash())
+	s.lk.Unlock()
+
+	switch {
+	case s.confirm == nil:
+		return added, nil
+	case added:
+		return true, s.confirm.add(ctx, c, depth)
+	default:
+		return s.confirm.Visit(ctx, c, depth)
+	}
+}
+
+// WithVisitedSet is a WalkOption recording the visited nodes in set. Nodes
+// already in the set are skipped without calling the visit function. It
+// replaces the set of nodes kept in memory by FetchGraph.
+func WithVisitedSet(set VisitedSet) WalkOption {
+	return func(walkOptions *walkOptions) {
+		walkOptions.VisitedSet = set
+	}
+}
+
+// walkFallible runs walk with a visit function which can fail. The first
+// error of visit cancels the walk, and is returned instead of the error of
+// walk.
+func walkFallible(ctx context.Context, visit func(context.Context, cid.Cid, int) (bool, error), walk func(context.Context, func(cid.Cid, int) bool) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// visit is never called concurrently
+	var visitErr error
+	err := walk(ctx, func(c cid.Cid, depth int) bool {
+		if visitErr != nil {
+			return false
+		}
+		ok, err := visit(ctx, c, depth)
+		if err != nil {
+			visitErr = err
+			cancel()
+			return false
+		}
+		return ok
+	})
+	if visitErr != nil {
+		return visitErr
+	}
+	return err
+}
+
+// visitWithSet wraps visit to skip the nodes already in set.
+func visitWithSet(set VisitedSet, visit func(cid.Cid, int) bool) func(context.Context, cid.Cid, int) (bool, error) {
+	return func(ctx context.Context, c cid.Cid, depth int) (bool, error) {
+		ok, err := set.Visit(ctx, c, depth)
+		if !ok || err != nil {
+			return false, err
+		}
+		return visit(c, depth), nil
+	}
+}
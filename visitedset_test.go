@@ -0,0 +1,120 @@
+package merkledag_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func TestVisitedSets(t *testing.T) {
+	ctx := context.Background()
+	// a filter this small reports most nodes as possibly visited
+	bloom, err := NewBloomVisitedSet(1, 0.5, ds.NewMapDatastore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := map[string]VisitedSet{
+		"memory":    NewMemoryVisitedSet(),
+		"datastore": NewDatastoreVisitedSet(ds.NewMapDatastore()),
+		"bloom":     bloom,
+	}
+
+	for name, set := range sets {
+		for i := 0; i < 20; i++ {
+			c := NewRawNode([]byte{byte(i)}).Cid()
+			for _, step := range []struct {
+				depth int
+				visit bool
+			}{{2, true}, {3, false}, {2, false}, {1, true}, {1, false}} {
+				ok, err := set.Visit(ctx, c, step.depth)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != step.visit {
+					t.Fatalf("%s: node %d at depth %d: expected %t, got %t", name, i, step.depth, step.visit, ok)
+				}
+			}
+		}
+	}
+}
+
+// failingVisitedSet fails once it holds a given number of nodes.
+type failingVisitedSet struct {
+	VisitedSet
+	left int
+}
+
+var errSetFull = errors.New("visited set is full")
+
+func (s *failingVisitedSet) Visit(ctx context.Context, c cid.Cid, depth int) (bool, error) {
+	if s.left == 0 {
+		return false, errSetFull
+	}
+	ok, err := s.VisitedSet.Visit(ctx, c, depth)
+	if ok {
+		s.left--
+	}
+	return ok, err
+}
+
+func TestFetchGraphWithVisitedSet(t *testing.T) {
+	ctx := context.Background()
+	src := dstest.Mock()
+	sub := makeTree(t, src, 2, 3, "sub")
+	root := NodeWithData([]byte("root"))
+	for _, name := range []string{"a", "b"} {
+		if err := root.AddNodeLink(name, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	all := cid.NewSet()
+	if err := Walk(ctx, GetLinksDirect(src), root.Cid(), all.Visit); err != nil {
+		t.Fatal(err)
+	}
+
+	set := NewDatastoreVisitedSet(dssync.MutexWrap(ds.NewMapDatastore()))
+	dserv := &interruptingDAG{DAGService: src, fetched: cid.NewSet()}
+	if err := FetchGraph(ctx, root.Cid(), dserv, WithVisitedSet(set)); err != nil {
+		t.Fatal(err)
+	}
+	if dserv.gets != all.Len() || dserv.fetched.Len() != all.Len() {
+		t.Fatalf("expected %d nodes to be fetched once, got %d fetches of %d nodes", all.Len(), dserv.gets, dserv.fetched.Len())
+	}
+	if ok, err := set.Visit(ctx, sub.Cid(), 0); err != nil || ok {
+		t.Fatalf("expected the set to hold the fetched nodes, got %t, %v", ok, err)
+	}
+
+	// walks skip the nodes already in the set
+	var visited int
+	err := WalkDepth(ctx, GetLinksDirect(src), root.Cid(), func(cid.Cid, int) bool {
+		visited++
+		return true
+	}, WithVisitedSet(NewMemoryVisitedSet()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visited != all.Len() {
+		t.Fatalf("expected %d visits, got %d", all.Len(), visited)
+	}
+
+	// errors of the set abort the walk
+	failing := &failingVisitedSet{VisitedSet: NewMemoryVisitedSet(), left: 3}
+	if err := FetchGraph(ctx, root.Cid(), src, WithVisitedSet(failing)); err != errSetFull {
+		t.Fatalf("expected %v, got %v", errSetFull, err)
+	}
+	failing = &failingVisitedSet{VisitedSet: NewMemoryVisitedSet(), left: 3}
+	err = Walk(ctx, GetLinksDirect(src), root.Cid(), func(cid.Cid) bool { return true }, WithVisitedSet(failing))
+	if err != errSetFull {
+		t.Fatalf("expected %v, got %v", errSetFull, err)
+	}
+}
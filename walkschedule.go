@@ -0,0 +1,196 @@
+package merkledag
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// RateLimit is a WalkOption limiting the walk to perSecond fetches on average,
+// with bursts of up to burst fetches. The limit is shared by all the walks
+// using the returned WalkOption, so several walks over a shared exchange can
+// be limited together. A rate of 0 or less means no limit.
+func RateLimit(perSecond float64, burst int) WalkOption {
+	var bucket *tokenBucket
+	if perSecond > 0 {
+		bucket = &tokenBucket{rate: perSecond, burst: float64(max(burst, 1))}
+	}
+	return func(walkOptions *walkOptions) {
+		walkOptions.RateLimiter = bucket
+	}
+}
+
+// Priority is a WalkOption fetching the nodes with the highest score first,
+// instead of in the order they were found. Nodes with the same score are
+// fetched in the order they were found.
+//
+// Priority makes the walk use the concurrent walker, with a single fetcher
+// unless Concurrent or Concurrency is also used.
+func Priority(score func(c cid.Cid, depth int) float64) WalkOption {
+	return func(walkOptions *walkOptions) {
+		walkOptions.Priority = score
+	}
+}
+
+// ShallowFirst is a Priority fetching the nodes closest to the root first.
+func ShallowFirst() WalkOption {
+	return Priority(func(_ cid.Cid, depth int) float64 {
+		return -float64(depth)
+	})
+}
+
+// AdaptiveConcurrency is a WalkOption adjusting the number of concurrent
+// fetches between minWorkers and maxWorkers, according to their latency: it
+// grows slowly while fetches take less than target, and is halved when they
+// take longer.
+func AdaptiveConcurrency(minWorkers, maxWorkers int, target time.Duration) WalkOption {
+	return func(walkOptions *walkOptions) {
+		walkOptions.Concurrency = max(maxWorkers, 1)
+		walkOptions.MinConcurrency = min(max(minWorkers, 1), walkOptions.Concurrency)
+		walkOptions.TargetLatency = target
+	}
+}
+
+// tokenBucket limits the rate of fetches. Its methods do nothing on a nil
+// tokenBucket.
+type tokenBucket struct {
+	lk     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// wait takes a token, waiting for one to be available.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	b.lk.Lock()
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = b.burst
+	} else {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	// take the token now, so later callers wait after us
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.lk.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.lk.Lock()
+		b.tokens++
+		b.lk.Unlock()
+		return ctx.Err()
+	}
+}
+
+// concurrencyLimit adjusts the number of concurrent fetches with additive
+// increase and multiplicative decrease.
+type concurrencyLimit struct {
+	min, max int
+	target   time.Duration
+
+	limit  int
+	credit int
+}
+
+// observe adjusts the limit with the latency of a fetch.
+func (l *concurrencyLimit) observe(latency time.Duration) {
+	if latency > l.target {
+		l.limit = max(l.min, l.limit/2)
+		l.credit = 0
+		return
+	}
+	// grow by one once a full window of fetches was fast enough
+	l.credit++
+	if l.credit >= l.limit {
+		l.limit = min(l.max, l.limit+1)
+		l.credit = 0
+	}
+}
+
+// flightQueue holds the flights of parallelWalkDepth yet to be fetched, in
+// the order they were found, or by decreasing score if there is a score
+// function.
+type flightQueue struct {
+	flights []*flight
+	score   func(cid.Cid, int) float64
+	seq     uint64
+}
+
+func (q *flightQueue) push(f *flight) {
+	if q.score == nil {
+		q.flights = append(q.flights, f)
+		return
+	}
+	f.score = q.score(f.cid, f.depth)
+	f.seq = q.seq
+	q.seq++
+	heap.Push(q, f)
+}
+
+// pop returns the next flight, or nil if there is none.
+func (q *flightQueue) pop() *flight {
+	if len(q.flights) == 0 {
+		return nil
+	}
+	if q.score == nil {
+		f := q.flights[0]
+		q.flights[0] = nil
+		q.flights = q.flights[1:]
+		return f
+	}
+	return heap.Pop(q).(*flight)
+}
+
+// reorder returns the best of next, which was popped earlier, and the queued
+// flights. The other one is queued.
+func (q *flightQueue) reorder(next *flight) *flight {
+	if q.score == nil || next == nil || len(q.flights) == 0 || !q.before(q.flights[0], next) {
+		return next
+	}
+	best := q.flights[0]
+	q.flights[0] = next
+	heap.Fix(q, 0)
+	return best
+}
+
+func (q *flightQueue) before(a, b *flight) bool {
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	return a.seq < b.seq
+}
+
+// heap.Interface, used with a score function
+
+func (q *flightQueue) Len() int           { return len(q.flights) }
+func (q *flightQueue) Less(i, j int) bool { return q.before(q.flights[i], q.flights[j]) }
+func (q *flightQueue) Swap(i, j int)      { q.flights[i], q.flights[j] = q.flights[j], q.flights[i] }
+func (q *flightQueue) Push(x any)         { q.flights = append(q.flights, x.(*flight)) }
+
+func (q *flightQueue) Pop() any {
+	n := len(q.flights) - 1
+	f := q.flights[n]
+	q.flights[n] = nil
+	q.flights = q.flights[:n]
+	return f
+}
@@ -0,0 +1,145 @@
+package merkledag_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/ipfs/go-merkledag"
+	dstest "github.com/ipfs/go-merkledag/test"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// concurrencyDAG records the highest number of concurrent fetches.
+type concurrencyDAG struct {
+	ipld.DAGService
+	delay time.Duration
+
+	lk      sync.Mutex
+	current int
+	highest int
+}
+
+func (d *concurrencyDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	d.lk.Lock()
+	d.current++
+	d.highest = max(d.highest, d.current)
+	d.lk.Unlock()
+
+	time.Sleep(d.delay)
+
+	d.lk.Lock()
+	d.current--
+	d.lk.Unlock()
+	return d.DAGService.Get(ctx, c)
+}
+
+func TestWalkPriority(t *testing.T) {
+	ctx := context.Background()
+	dserv := dstest.Mock()
+	root := makeTree(t, dserv, 3, 3, "root")
+
+	var depths []int
+	err := WalkDepth(ctx, GetLinksDirect(dserv), root.Cid(), func(_ cid.Cid, depth int) bool {
+		depths = append(depths, depth)
+		return true
+	}, ShallowFirst())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(depths); i++ {
+		if depths[i] < depths[i-1] {
+			t.Fatalf("nodes were not visited shallow first: %v", depths)
+		}
+	}
+
+	// the critical subtree is walked before any other node
+	critical := cid.NewSet()
+	if err := Walk(ctx, GetLinksDirect(dserv), root.Links()[2].Cid, critical.Visit); err != nil {
+		t.Fatal(err)
+	}
+	var order []cid.Cid
+	err = Walk(ctx, GetLinksDirect(dserv), root.Cid(), func(c cid.Cid) bool {
+		order = append(order, c)
+		return true
+	}, Priority(func(c cid.Cid, _ int) float64 {
+		if critical.Has(c) {
+			return 1
+		}
+		return 0
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range order[1 : 1+critical.Len()] {
+		if !critical.Has(c) {
+			t.Fatalf("node %d is not in the critical subtree", i+1)
+		}
+	}
+}
+
+func TestWalkRateLimit(t *testing.T) {
+	ctx := context.Background()
+	dserv := dstest.Mock()
+	root := makeTree(t, dserv, 2, 3, "root")
+
+	// 13 nodes: the first one uses the burst, the others wait 10ms each
+	for _, concurrency := range []int{1, 4} {
+		start := time.Now()
+		err := Walk(ctx, GetLinksDirect(dserv), root.Cid(), cid.NewSet().Visit, Concurrency(concurrency), RateLimit(100, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 110*time.Millisecond {
+			t.Fatalf("concurrency %d: walk took %s, faster than the rate limit", concurrency, elapsed)
+		}
+	}
+
+	// a rate of 0 lifts the limit
+	start := time.Now()
+	err := Walk(ctx, GetLinksDirect(dserv), root.Cid(), cid.NewSet().Visit, RateLimit(100, 1), RateLimit(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Fatalf("unlimited walk took %s", elapsed)
+	}
+
+	// a canceled walk doesn't wait
+	limit := RateLimit(0.01, 1)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = Walk(canceled, GetLinksDirect(dserv), root.Cid(), cid.NewSet().Visit, limit)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWalkAdaptiveConcurrency(t *testing.T) {
+	ctx := context.Background()
+	src := dstest.Mock()
+	root := makeTree(t, src, 3, 4, "root")
+
+	// fetches slower than the target keep the concurrency at its minimum
+	slow := &concurrencyDAG{DAGService: src, delay: 2 * time.Millisecond}
+	err := Walk(ctx, GetLinksDirect(slow), root.Cid(), cid.NewSet().Visit, AdaptiveConcurrency(2, 16, time.Microsecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slow.highest != 2 {
+		t.Fatalf("expected 2 concurrent fetches, got %d", slow.highest)
+	}
+
+	// fast ones let it grow
+	fast := &concurrencyDAG{DAGService: src, delay: 2 * time.Millisecond}
+	err = Walk(ctx, GetLinksDirect(fast), root.Cid(), cid.NewSet().Visit, AdaptiveConcurrency(2, 16, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fast.highest <= 2 || fast.highest > 16 {
+		t.Fatalf("expected between 3 and 16 concurrent fetches, got %d", fast.highest)
+	}
+}